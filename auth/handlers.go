@@ -5,11 +5,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/referrals"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/oauth2"
 	"gorm.io/gorm"
@@ -20,10 +23,11 @@ type Handler struct {
 	GoogleOAuth *GoogleOAuth
 }
 
-// OAuthState contains both CSRF token and referral code
+// OAuthState contains the CSRF token, referral code and referral click token
 type OAuthState struct {
-	CSRF    string `json:"csrf"`
-	RefCode string `json:"ref_code,omitempty"`
+	CSRF       string `json:"csrf"`
+	RefCode    string `json:"ref_code,omitempty"`
+	ClickToken string `json:"click_token,omitempty"`
 }
 
 func NewHandler(db *gorm.DB) *Handler {
@@ -41,10 +45,18 @@ func (h *Handler) InitiateGoogleLogin(c *gin.Context) {
 	// Capture referral code from query parameter (e.g., ?ref=drew)
 	refCode := c.Query("ref")
 
-	// Create OAuthState object with CSRF and referral code
+	// Capture the tracked referral click, from ?click= or the cookie set when
+	// the referral link was opened
+	clickToken := c.Query("click")
+	if clickToken == "" {
+		clickToken, _ = c.Cookie(referrals.ClickCookie)
+	}
+
+	// Create OAuthState object with CSRF, referral code and click
 	oauthState := OAuthState{
-		CSRF:    csrfToken,
-		RefCode: refCode,
+		CSRF:       csrfToken,
+		RefCode:    refCode,
+		ClickToken: clickToken,
 	}
 
 	// JSON marshal and Base64 encode the state
@@ -121,21 +133,41 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 			Locale:        googleUser.Locale,
 		}
 
-		// Check if a referral code was provided in the OAuth state
-		if oauthState.RefCode != "" {
-			// Find the referrer by their referral code
-			var referrer models.User
-			if err := h.DB.Where("referral_code = ?", oauthState.RefCode).First(&referrer).Error; err == nil {
-				// Referrer found - link this new user to them
-				user.ReferredByUserID = &referrer.ID
-			}
-			// Note: If referrer not found, we silently continue (invalid/expired code)
+		// Look up the tracked referral click, if any
+		click, err := referrals.FindClick(h.DB, oauthState.ClickToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
 		}
 
+		// Check if a referral code was provided in the OAuth state, falling
+		// back to the referrer of the click
+		var referrer models.User
+		referred := false
+		if code := strings.ToLower(strings.TrimSpace(oauthState.RefCode)); code != "" {
+			// Find the referrer by their referral code, which is stored lowercase
+			referred = h.DB.Where("referral_code = ? AND referral_code_active", code).First(&referrer).Error == nil
+		}
+		if !referred && click != nil {
+			referred = h.DB.Where("id = ? AND referral_code_active", click.ReferrerUserID).First(&referrer).Error == nil
+		}
+		if referred {
+			// Referrer found - link this new user to them
+			user.ReferredByUserID = &referrer.ID
+		}
+		// Note: If referrer not found, we silently continue (invalid/expired/inactive code)
+
 		if err := h.DB.Create(&user).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
 			return
 		}
+
+		// Credit the signup to the click if it was for the same referrer
+		if referred && click != nil && click.ReferrerUserID == referrer.ID {
+			if err := referrals.RecordSignup(h.DB, click.ID, user.ID); err != nil {
+				log.Printf("Failed to record signup of user %d for referral click %d: %v", user.ID, click.ID, err)
+			}
+		}
 	} else if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -181,8 +213,9 @@ func (h *Handler) GoogleCallback(c *gin.Context) {
 		true,            // httpOnly (not accessible via JavaScript)
 	)
 
-	// Clear the oauth_state cookie
+	// Clear the oauth_state and referral click cookies
 	c.SetCookie("oauth_state", "", -1, "/", "", false, true)
+	c.SetCookie(referrals.ClickCookie, "", -1, "/", "", false, true)
 
 	// Redirect to frontend
 	frontendURL := os.Getenv("FRONTEND_URL")
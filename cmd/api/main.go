@@ -2,20 +2,33 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/drewmudry/instashorts-api/auth"
 	"github.com/drewmudry/instashorts-api/internal/platform"
 	"github.com/drewmudry/instashorts-api/referrals"
 	"github.com/drewmudry/instashorts-api/series"
 	stripehandlers "github.com/drewmudry/instashorts-api/stripe"
+	"github.com/drewmudry/instashorts-api/usage"
 	"github.com/drewmudry/instashorts-api/webhooks"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
+// shutdownTimeout is how long the server waits for in-flight requests on shutdown.
+const shutdownTimeout = 15 * time.Second
+
 type Server struct {
 	DB     *gorm.DB
 	Redis  *redis.Client
@@ -36,12 +49,24 @@ func NewServer() (*Server, error) {
 		c.Next()
 	})
 
+	// Tag every request with an ID (reusing the caller's X-Request-ID if present)
+	// so queued tasks can be correlated back to the request that caused them.
+	router.Use(func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Writer.Header().Set("X-Request-ID", requestID)
+		c.Next()
+	})
+
 	// Add CORS middleware for your frontend
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", os.Getenv("FRONTEND_URL"))
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, X-Request-ID, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -90,6 +115,7 @@ func (s *Server) setupRoutes() {
 	stripeHandler := stripehandlers.NewHandler(s.DB)
 	webhookHandler := webhooks.NewHandler(s.DB)
 	seriesHandler := series.NewHandler(s.DB, s.Redis)
+	usageHandler := usage.NewHandler(s.DB)
 
 	// Public routes
 	// Root route - no auth needed
@@ -103,6 +129,9 @@ func (s *Server) setupRoutes() {
 		webhookRoutes.POST("/stripe", webhookHandler.HandleStripeWebhook)
 	}
 
+	// Referral link click tracking (public - visitors aren't signed in yet)
+	s.Router.POST("/referrals/clicks", referralHandler.TrackClick)
+
 	// Auth routes (public - no auth middleware)
 	authRoutes := s.Router.Group("/auth")
 	{
@@ -130,6 +159,8 @@ func (s *Server) setupRoutes() {
 		{
 			stripeRoutes.POST("/connect-onboarding", stripeHandler.CreateConnectOnboardingLink)
 			stripeRoutes.GET("/connect-status", stripeHandler.GetConnectAccountStatus)
+			stripeRoutes.POST("/checkout", stripeHandler.CreateCheckoutSession)
+			stripeRoutes.POST("/billing-portal", stripeHandler.CreateBillingPortalSession)
 		}
 
 		// Series routes
@@ -138,6 +169,26 @@ func (s *Server) setupRoutes() {
 			seriesRoutes.POST("", seriesHandler.CreateSeries)
 			seriesRoutes.GET("", seriesHandler.GetUserSeries)
 			seriesRoutes.GET("/:id/videos", seriesHandler.GetSeriesVideos)
+			seriesRoutes.PATCH("/:id", seriesHandler.UpdateSeries)
+			seriesRoutes.DELETE("/:id", seriesHandler.DeleteSeries)
+			seriesRoutes.PUT("/:id/schedule", seriesHandler.UpdateSeriesSchedule)
+		}
+
+		// LLM usage and cost, and plan quota
+		protected.GET("/usage", usageHandler.GetUsageSummary)
+		protected.GET("/usage/quota", usageHandler.GetQuota)
+
+		// Admin endpoints, restricted to ADMIN_EMAILS
+		adminRoutes := protected.Group("/admin")
+		adminRoutes.Use(auth.AdminMiddleware())
+		{
+			adminRoutes.GET("/stripe-events", webhookHandler.ListStripeEvents)
+			adminRoutes.POST("/stripe-events/:id/replay", webhookHandler.ReplayStripeEvent)
+			adminRoutes.GET("/commission-rules", referralHandler.ListCommissionRules)
+			adminRoutes.PUT("/commission-rules", referralHandler.PutCommissionRule)
+			adminRoutes.DELETE("/commission-rules/:id", referralHandler.DeleteCommissionRule)
+			adminRoutes.GET("/referral-payouts", referralHandler.ListPayouts)
+			adminRoutes.POST("/referral-payouts/:id/retry", referralHandler.RetryPayout)
 		}
 
 		// Example protected route
@@ -157,23 +208,73 @@ func (s *Server) setupRoutes() {
 	}
 }
 
-func (s *Server) Run() error {
+// Run serves HTTP until ctx is cancelled, then stops accepting connections and
+// waits up to shutdownTimeout for in-flight requests to finish.
+func (s *Server) Run(ctx context.Context) error {
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	log.Printf("🚀 Server starting on port %s", port)
-	return s.Router.Run(":" + port)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: s.Router,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("🚀 Server starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down server, draining requests for up to %s", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// Close releases the server's database and Redis connections.
+func (s *Server) Close() {
+	if sqlDB, err := s.DB.DB(); err == nil {
+		sqlDB.Close()
+	}
+	s.Redis.Close()
+}
+
+// newRequestID returns a random hex identifier for an incoming request.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
 }
 
 func main() {
+	// Cancelled on SIGINT/SIGTERM so in-flight requests can finish before exit.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	server, err := NewServer()
 	if err != nil {
 		log.Fatal("Failed to create server:", err)
 	}
+	defer server.Close()
 
-	if err := server.Run(); err != nil {
+	if err := server.Run(ctx); err != nil {
 		log.Fatal("Failed to run server:", err)
 	}
+	log.Println("Server stopped")
 }
@@ -2,35 +2,136 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"expvar"
+	"flag"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/drewmudry/instashorts-api/internal/platform"
+	"github.com/drewmudry/instashorts-api/processing"
 	"github.com/drewmudry/instashorts-api/tasks"
 	"github.com/drewmudry/instashorts-api/worker"
 )
 
 func main() {
+	// Dead-letter maintenance flags, e.g. `go run cmd/worker/main.go -replay q_video_title`
+	listDead := flag.String("dead", "", "print the dead letters for a queue and exit")
+	replayDead := flag.String("replay", "", "replay the dead letters for a queue and exit")
+	limit := flag.Int64("limit", 100, "maximum number of dead letters to print or replay")
+	flag.Parse()
+
 	// Use the shared initializers
 	db := platform.NewDBConnection()
 	rdb := platform.NewRedisClient()
-	ctx := context.Background()
+
+	// Cancelled on SIGINT/SIGTERM so Listen can drain in-flight tasks before exiting.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Dead-letter maintenance only touches Redis, so it runs without an LLM.
+	if *listDead != "" {
+		letters, err := worker.NewProcessor(db, rdb, nil).DeadLetters(ctx, *listDead, *limit)
+		if err != nil {
+			log.Fatalf("Failed to read dead letters for %s: %v", *listDead, err)
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(letters)
+		return
+	}
+	if *replayDead != "" {
+		n, err := worker.NewProcessor(db, rdb, nil).ReplayDeadLetters(ctx, *replayDead, *limit)
+		if err != nil {
+			log.Fatalf("Failed to replay dead letters for %s: %v", *replayDead, err)
+		}
+		log.Printf("Replayed %d dead letters onto %s", n, *replayDead)
+		return
+	}
+
+	// LLM provider for the generation steps, chosen by LLM_PROVIDER.
+	llm, err := processing.NewLLMFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure LLM provider: %v", err)
+	}
 
 	// Create the new processor
-	proc := worker.NewProcessor(db, rdb)
+	proc := worker.NewProcessor(db, rdb, llm)
+
+	// Middleware shared by every handler. The first one registered runs outermost.
+	metrics := worker.NewMetrics("worker_tasks")
+	proc.Use(
+		worker.Tracing(),
+		worker.Logging(nil),
+		metrics.Middleware(),
+		worker.Recover(),
+		worker.Timeout(5*time.Minute, map[string]time.Duration{
+			tasks.QueueVideoTitle: time.Minute,
+		}),
+	)
+
+	// Expose task metrics at /debug/vars when an address is configured.
+	if addr := os.Getenv("WORKER_METRICS_ADDR"); addr != "" {
+		go func() {
+			log.Printf("Serving worker metrics on %s/debug/vars", addr)
+			if err := http.ListenAndServe(addr, expvar.Handler()); err != nil {
+				log.Printf("Metrics server stopped: %v", err)
+			}
+		}()
+	}
 
 	// Register all task handlers
 	proc.Register(tasks.QueueVideoTitle, proc.HandleTitleGeneration)
 	proc.Register(tasks.QueueSceneGeneration, proc.HandleSceneGeneration)
 	proc.Register(tasks.QueueVideoScript, proc.HandleScriptGeneration)
+	proc.OnExpired(tasks.QueueVideoTitle, proc.HandleExpiredVideo)
 	// proc.Register(tasks.QueueVideoRender, proc.HandleRenderVideo) // DISABLED: rendering has issues
 
+	// LLM-backed steps are the likeliest to hit transient errors, so give them more room.
+	proc.SetRetryPolicy(tasks.QueueSceneGeneration, worker.RetryPolicy{
+		MaxAttempts: 6,
+		BaseDelay:   10 * time.Second,
+		MaxDelay:    15 * time.Minute,
+	})
+
+	// Per-queue consumer pools. Title generation is quick, so it gets the most
+	// consumers; the heavier scene and script steps are capped lower.
+	proc.SetConcurrency(tasks.QueueVideoTitle, envInt("WORKER_TITLE_CONCURRENCY", 8))
+	proc.SetConcurrency(tasks.QueueSceneGeneration, envInt("WORKER_SCENE_CONCURRENCY", 4))
+	proc.SetConcurrency(tasks.QueueVideoScript, envInt("WORKER_SCRIPT_CONCURRENCY", 4))
+
 	log.Println("Worker started, waiting for queue tasks...")
 
-	// Start listening. This is a blocking call.
+	if d, err := time.ParseDuration(os.Getenv("WORKER_SHUTDOWN_TIMEOUT")); err == nil {
+		proc.ShutdownTimeout = d
+	}
+
+	// Start listening. This blocks until a shutdown signal is received and
+	// in-flight tasks have finished or been requeued.
 	proc.Listen(ctx,
 		tasks.QueueVideoTitle,
 		tasks.QueueSceneGeneration,
 		tasks.QueueVideoScript,
 		// tasks.QueueVideoRender, // DISABLED: rendering has issues
 	)
+
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+	rdb.Close()
+	log.Println("Worker exited cleanly")
+}
+
+// envInt reads a positive integer from the environment, falling back to def.
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v < 1 {
+		return def
+	}
+	return v
 }
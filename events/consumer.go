@@ -0,0 +1,207 @@
+package events
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Handler processes one event. Returning an error leaves the event pending so
+// it is delivered again later.
+type Handler func(ctx context.Context, evt Event) error
+
+// Consumer reads a stream as a member of a consumer group. Each event is
+// delivered to one member of the group and acknowledged once handled; events
+// left pending by a crashed member are reclaimed after MinIdle.
+//
+// Name should be stable across restarts: Redis keeps every consumer name a
+// group has seen, so a name per process leaves the group growing forever.
+type Consumer struct {
+	RDB    *redis.Client
+	Stream string
+	Group  string
+	Name   string
+
+	// Block is how long a read waits for new events.
+	Block time.Duration
+	// MinIdle is how long an event must sit unacknowledged before it is reclaimed.
+	MinIdle time.Duration
+	// MaxDeliveries is how many times an event is tried before it is dropped.
+	MaxDeliveries int64
+	// Active, if set, pauses consumption while it returns false (for example
+	// while this process isn't the leader).
+	Active func() bool
+}
+
+// NewConsumer creates a consumer with sensible defaults.
+func NewConsumer(rdb *redis.Client, stream, group, name string) *Consumer {
+	return &Consumer{
+		RDB:           rdb,
+		Stream:        stream,
+		Group:         group,
+		Name:          name,
+		Block:         5 * time.Second,
+		MinIdle:       time.Minute,
+		MaxDeliveries: 10,
+	}
+}
+
+// Run consumes events until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context, handler Handler) error {
+	// Start from the beginning of the stream the first time the group is
+	// created so events published before any consumer existed aren't skipped.
+	err := c.RDB.XGroupCreateMkStream(ctx, c.Stream, c.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	lastReclaim := time.Time{}
+	for ctx.Err() == nil {
+		if c.Active != nil && !c.Active() {
+			sleep(ctx, c.Block)
+			continue
+		}
+
+		if time.Since(lastReclaim) >= c.MinIdle/2 {
+			c.reclaim(ctx, handler)
+			lastReclaim = time.Now()
+		}
+
+		streams, err := c.RDB.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.Group,
+			Consumer: c.Name,
+			Streams:  []string{c.Stream, ">"},
+			Count:    10,
+			Block:    c.Block,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error reading %s as %s/%s: %v", c.Stream, c.Group, c.Name, err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				c.handle(ctx, msg, handler)
+			}
+		}
+	}
+	return nil
+}
+
+// reclaimBatch is how many pending events reclaim inspects at a time.
+const reclaimBatch = 50
+
+// reclaim takes over events another member (or an earlier run of this one)
+// read but never acknowledged. It uses XPENDING and XCLAIM rather than
+// XAUTOCLAIM, whose Redis 7 reply the go-redis v8 client can't parse.
+func (c *Consumer) reclaim(ctx context.Context, handler Handler) {
+	start := "-"
+	for {
+		pending, err := c.RDB.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream: c.Stream,
+			Group:  c.Group,
+			Idle:   c.MinIdle,
+			Start:  start,
+			End:    "+",
+			Count:  reclaimBatch,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error listing pending events on %s: %v", c.Stream, err)
+			}
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		var ids []string
+		for _, p := range pending {
+			if p.RetryCount >= c.MaxDeliveries {
+				log.Printf("Dropping event %s on %s after %d deliveries", p.ID, c.Stream, p.RetryCount)
+				c.ack(ctx, p.ID)
+				continue
+			}
+			ids = append(ids, p.ID)
+		}
+
+		if len(ids) > 0 {
+			// MinIdle is checked again, so an event another member claimed in
+			// the meantime is left to it.
+			msgs, err := c.RDB.XClaim(ctx, &redis.XClaimArgs{
+				Stream:   c.Stream,
+				Group:    c.Group,
+				Consumer: c.Name,
+				MinIdle:  c.MinIdle,
+				Messages: ids,
+			}).Result()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Error reclaiming pending events on %s: %v", c.Stream, err)
+				}
+				return
+			}
+			for _, msg := range msgs {
+				c.handle(ctx, msg, handler)
+			}
+		}
+
+		if len(pending) < reclaimBatch {
+			return
+		}
+		start = nextID(pending[len(pending)-1].ID)
+	}
+}
+
+// nextID returns the smallest stream ID after id, for paging through ranges
+// on servers without exclusive "(" range starts (Redis before 6.2).
+func nextID(id string) string {
+	ms, seq, ok := strings.Cut(id, "-")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if !ok || err != nil {
+		return id
+	}
+	return ms + "-" + strconv.FormatUint(n+1, 10)
+}
+
+// handle runs handler for one entry and acknowledges it on success. The
+// acknowledgement doesn't use ctx, so an event handled just as the consumer
+// shuts down isn't delivered again.
+func (c *Consumer) handle(ctx context.Context, msg redis.XMessage, handler Handler) {
+	evt, err := decode(msg)
+	if err != nil {
+		log.Printf("Dropping malformed event on %s: %v", c.Stream, err)
+		c.ack(context.Background(), msg.ID)
+		return
+	}
+
+	if err := handler(ctx, evt); err != nil {
+		log.Printf("Error handling %s event %s: %v", evt.Type, evt.ID, err)
+		return
+	}
+	c.ack(context.Background(), msg.ID)
+}
+
+func (c *Consumer) ack(ctx context.Context, id string) {
+	if err := c.RDB.XAck(ctx, c.Stream, c.Group, id).Err(); err != nil {
+		log.Printf("Error acknowledging event %s on %s: %v", id, c.Stream, err)
+	}
+}
+
+// sleep waits for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,212 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	testStream = "events:test"
+	testGroup  = "test-group"
+)
+
+// newTestConsumer returns a consumer on an in-memory Redis whose group has
+// been created, with timings short enough for tests.
+func newTestConsumer(t *testing.T) (*Consumer, *Bus) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	if err := rdb.XGroupCreateMkStream(context.Background(), testStream, testGroup, "0").Err(); err != nil {
+		t.Fatal(err)
+	}
+	c := NewConsumer(rdb, testStream, testGroup, "leader")
+	c.Block = 10 * time.Millisecond
+	c.MinIdle = 20 * time.Millisecond
+	return c, NewBus(rdb)
+}
+
+func publish(t *testing.T, bus *Bus, seriesIDs ...uint) {
+	t.Helper()
+	for _, id := range seriesIDs {
+		if _, err := bus.Publish(context.Background(), testStream, Event{Type: SeriesCreated, SeriesID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readUnacked delivers every new event to another member of the group that
+// never acknowledges them, as if it crashed.
+func readUnacked(t *testing.T, c *Consumer) {
+	t.Helper()
+	err := c.RDB.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+		Group:    c.Group,
+		Consumer: "crashed",
+		Streams:  []string{c.Stream, ">"},
+		Count:    1000,
+		Block:    -1,
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pendingCount(t *testing.T, c *Consumer) int64 {
+	t.Helper()
+	pending, err := c.RDB.XPending(context.Background(), c.Stream, c.Group).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending.Count
+}
+
+func TestRunHandlesAndAcknowledgesEvents(t *testing.T) {
+	c, bus := newTestConsumer(t)
+	publish(t, bus, 1, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := make(chan Event, 10)
+	done := make(chan error)
+	go func() {
+		done <- c.Run(ctx, func(ctx context.Context, evt Event) error {
+			got <- evt
+			return nil
+		})
+	}()
+
+	for _, want := range []uint{1, 2} {
+		select {
+		case evt := <-got:
+			if evt.SeriesID != want || evt.Type != SeriesCreated || evt.ID == "" {
+				t.Errorf("got event %+v, want series %d created", evt, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event for series %d never arrived", want)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if n := pendingCount(t, c); n != 0 {
+		t.Errorf("%d events left pending after being handled", n)
+	}
+}
+
+func TestReclaimTakesOverIdleEvents(t *testing.T) {
+	c, bus := newTestConsumer(t)
+	publish(t, bus, 1)
+	readUnacked(t, c)
+
+	var handled []uint
+	handler := func(ctx context.Context, evt Event) error {
+		handled = append(handled, evt.SeriesID)
+		return nil
+	}
+
+	// Not idle for long enough yet
+	c.reclaim(context.Background(), handler)
+	if len(handled) != 0 {
+		t.Fatalf("reclaimed an event before MinIdle: %v", handled)
+	}
+
+	time.Sleep(2 * c.MinIdle)
+	c.reclaim(context.Background(), handler)
+	if len(handled) != 1 || handled[0] != 1 {
+		t.Fatalf("handled %v, want the crashed member's event", handled)
+	}
+	if n := pendingCount(t, c); n != 0 {
+		t.Errorf("%d events left pending after reclaiming", n)
+	}
+}
+
+func TestReclaimPagesThroughPendingEvents(t *testing.T) {
+	c, bus := newTestConsumer(t)
+	var ids []uint
+	for i := uint(1); i <= reclaimBatch+5; i++ {
+		ids = append(ids, i)
+	}
+	publish(t, bus, ids...)
+	readUnacked(t, c)
+	time.Sleep(2 * c.MinIdle)
+
+	handled := 0
+	c.reclaim(context.Background(), func(ctx context.Context, evt Event) error {
+		handled++
+		return nil
+	})
+	if handled != len(ids) {
+		t.Errorf("reclaimed %d events, want %d", handled, len(ids))
+	}
+}
+
+func TestReclaimDropsEventsAfterMaxDeliveries(t *testing.T) {
+	c, bus := newTestConsumer(t)
+	c.MaxDeliveries = 2
+	publish(t, bus, 1)
+	readUnacked(t, c) // First delivery
+
+	attempts := 0
+	failing := func(ctx context.Context, evt Event) error {
+		attempts++
+		return fmt.Errorf("attempt %d failed", attempts)
+	}
+
+	time.Sleep(2 * c.MinIdle)
+	c.reclaim(context.Background(), failing) // Second delivery
+	if attempts != 1 {
+		t.Fatalf("handler ran %d times, want 1", attempts)
+	}
+	if n := pendingCount(t, c); n != 1 {
+		t.Fatalf("%d events pending after a failure, want 1", n)
+	}
+
+	time.Sleep(2 * c.MinIdle)
+	c.reclaim(context.Background(), failing)
+	if attempts != 1 {
+		t.Errorf("handler ran again after %d deliveries", c.MaxDeliveries)
+	}
+	if n := pendingCount(t, c); n != 0 {
+		t.Errorf("exhausted event still pending")
+	}
+}
+
+func TestHandleDropsMalformedEvents(t *testing.T) {
+	c, _ := newTestConsumer(t)
+	if err := c.RDB.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: testStream,
+		Values: map[string]interface{}{"type": "junk"},
+	}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	readUnacked(t, c)
+	time.Sleep(2 * c.MinIdle)
+
+	c.reclaim(context.Background(), func(ctx context.Context, evt Event) error {
+		t.Errorf("handler ran for a malformed event: %+v", evt)
+		return nil
+	})
+	if n := pendingCount(t, c); n != 0 {
+		t.Errorf("malformed event still pending")
+	}
+}
+
+func TestNextID(t *testing.T) {
+	tests := []struct{ id, want string }{
+		{"1700000000000-0", "1700000000000-1"},
+		{"1700000000000-41", "1700000000000-42"},
+		{"malformed", "malformed"},
+	}
+	for _, tt := range tests {
+		if got := nextID(tt.id); got != tt.want {
+			t.Errorf("nextID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ---
+// EVENT DEFINITIONS
+// ---
+// Domain events are appended to Redis Streams. Unlike pub/sub, a stream keeps
+// entries until they are trimmed, so consumers that are down or slow pick up
+// where they left off.
+
+const (
+	// SeriesStream carries every series lifecycle event.
+	SeriesStream = "events:series"
+
+	// defaultMaxLen caps each stream (approximately) so it can't grow forever.
+	defaultMaxLen = 10000
+)
+
+// Series event types.
+const (
+	SeriesCreated = "series.created"
+	SeriesUpdated = "series.updated"
+	SeriesPaused  = "series.paused"
+	SeriesResumed = "series.resumed"
+	SeriesDeleted = "series.deleted"
+)
+
+// Event is a single entry on a stream.
+type Event struct {
+	ID         string    `json:"-"` // Stream entry ID, set when read
+	Type       string    `json:"type"`
+	SeriesID   uint      `json:"series_id,omitempty"`
+	UserID     uint      `json:"user_id,omitempty"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
+// Bus publishes events to Redis Streams.
+type Bus struct {
+	RDB    *redis.Client
+	MaxLen int64
+}
+
+// NewBus creates an event bus backed by rdb.
+func NewBus(rdb *redis.Client) *Bus {
+	return &Bus{RDB: rdb, MaxLen: defaultMaxLen}
+}
+
+// Publish appends evt to stream and returns the new entry ID.
+func (b *Bus) Publish(ctx context.Context, stream string, evt Event) (string, error) {
+	if evt.OccurredAt.IsZero() {
+		evt.OccurredAt = time.Now().UTC()
+	}
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return "", err
+	}
+
+	return b.RDB.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		MaxLen: b.MaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"type": evt.Type,
+			"data": string(data),
+		},
+	}).Result()
+}
+
+// decode parses a stream entry written by Publish.
+func decode(msg redis.XMessage) (Event, error) {
+	raw, ok := msg.Values["data"].(string)
+	if !ok {
+		return Event{}, fmt.Errorf("event %s has no data field", msg.ID)
+	}
+	var evt Event
+	if err := json.Unmarshal([]byte(raw), &evt); err != nil {
+		return Event{}, fmt.Errorf("event %s: %w", msg.ID, err)
+	}
+	evt.ID = msg.ID
+	return evt, nil
+}
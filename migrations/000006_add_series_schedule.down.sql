@@ -0,0 +1,6 @@
+ALTER TABLE series
+    DROP COLUMN IF EXISTS schedule_cron,
+    DROP COLUMN IF EXISTS window_start,
+    DROP COLUMN IF EXISTS window_end,
+    DROP COLUMN IF EXISTS time_zone,
+    DROP COLUMN IF EXISTS days_of_week;
@@ -0,0 +1,8 @@
+-- Per-series posting schedule. Either schedule_cron is set (one video per
+-- firing) or posts_per_day videos are spaced across the daily window.
+ALTER TABLE series
+    ADD COLUMN schedule_cron VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN window_start VARCHAR(5) NOT NULL DEFAULT '09:00', -- HH:MM, local to time_zone
+    ADD COLUMN window_end VARCHAR(5) NOT NULL DEFAULT '21:00',
+    ADD COLUMN time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC', -- IANA name, e.g. 'America/New_York'
+    ADD COLUMN days_of_week TEXT NOT NULL DEFAULT '[]'; -- JSON array like ["mon","wed"]; empty means every day
@@ -0,0 +1,7 @@
+ALTER TABLE video_scenes
+    DROP COLUMN IF EXISTS narration,
+    DROP COLUMN IF EXISTS narration_word_count;
+
+ALTER TABLE seriesvideos
+    DROP COLUMN IF EXISTS hook,
+    DROP COLUMN IF EXISTS call_to_action;
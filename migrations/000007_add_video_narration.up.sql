@@ -0,0 +1,9 @@
+-- Structured voiceover script. seriesvideos.script keeps the assembled text;
+-- the hook, call to action and per-scene lines are stored alongside it.
+ALTER TABLE seriesvideos
+    ADD COLUMN hook TEXT NOT NULL DEFAULT '',
+    ADD COLUMN call_to_action TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE video_scenes
+    ADD COLUMN narration TEXT NOT NULL DEFAULT '', -- Everything spoken over the scene, including the hook or call to action
+    ADD COLUMN narration_word_count INT NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+ALTER TABLE series
+    DROP COLUMN IF EXISTS style_visual_style,
+    DROP COLUMN IF EXISTS style_color_grading,
+    DROP COLUMN IF EXISTS style_tone_of_voice,
+    DROP COLUMN IF EXISTS style_target_audience,
+    DROP COLUMN IF EXISTS style_narrator_persona,
+    DROP COLUMN IF EXISTS style_banned_topics;
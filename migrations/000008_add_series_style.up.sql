@@ -0,0 +1,9 @@
+-- Per-series style profile used by every generation prompt. Empty values fall
+-- back to the generator's defaults.
+ALTER TABLE series
+    ADD COLUMN style_visual_style TEXT NOT NULL DEFAULT '',
+    ADD COLUMN style_color_grading TEXT NOT NULL DEFAULT '',
+    ADD COLUMN style_tone_of_voice TEXT NOT NULL DEFAULT '',
+    ADD COLUMN style_target_audience TEXT NOT NULL DEFAULT '',
+    ADD COLUMN style_narrator_persona TEXT NOT NULL DEFAULT '',
+    ADD COLUMN style_banned_topics TEXT NOT NULL DEFAULT '[]'; -- JSON array of topics to avoid
@@ -0,0 +1,7 @@
+ALTER TABLE video_scenes
+    DROP COLUMN IF EXISTS prompt_version;
+
+ALTER TABLE seriesvideos
+    DROP COLUMN IF EXISTS title_prompt_version,
+    DROP COLUMN IF EXISTS scenes_prompt_version,
+    DROP COLUMN IF EXISTS script_prompt_version;
@@ -0,0 +1,9 @@
+-- Which prompt template version produced each video and scene, e.g. 'v2', so
+-- prompt changes can be A/B tested and rolled back.
+ALTER TABLE seriesvideos
+    ADD COLUMN title_prompt_version VARCHAR(20) NOT NULL DEFAULT '',
+    ADD COLUMN scenes_prompt_version VARCHAR(20) NOT NULL DEFAULT '', -- scene breakdown template
+    ADD COLUMN script_prompt_version VARCHAR(20) NOT NULL DEFAULT '';
+
+ALTER TABLE video_scenes
+    ADD COLUMN prompt_version VARCHAR(20) NOT NULL DEFAULT ''; -- scene prompt template
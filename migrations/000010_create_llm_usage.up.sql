@@ -0,0 +1,18 @@
+-- One row per LLM call. Series and video references are kept (not cascaded)
+-- after deletion so historical spend still adds up.
+CREATE TABLE IF NOT EXISTS llm_usage (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    series_id BIGINT,
+    video_id BIGINT,
+    step VARCHAR(50) NOT NULL, -- e.g. 'video_title', 'scene_prompt'
+    model VARCHAR(100) NOT NULL,
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    completion_tokens INT NOT NULL DEFAULT 0,
+    latency_ms INT NOT NULL DEFAULT 0,
+    cost_micros BIGINT NOT NULL DEFAULT 0, -- USD millionths
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_llm_usage_user_id_created_at ON llm_usage(user_id, created_at);
+CREATE INDEX idx_llm_usage_series_id ON llm_usage(series_id);
+CREATE INDEX idx_llm_usage_video_id ON llm_usage(video_id);
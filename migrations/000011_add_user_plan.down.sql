@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS stripe_subscription_id;
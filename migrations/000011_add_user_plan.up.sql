@@ -0,0 +1,4 @@
+-- Subscription plan kept in sync from Stripe webhooks.
+ALTER TABLE users
+    ADD COLUMN plan VARCHAR(50) NOT NULL DEFAULT 'free', -- free, pro
+    ADD COLUMN stripe_subscription_id VARCHAR(255) UNIQUE;
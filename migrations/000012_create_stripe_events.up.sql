@@ -0,0 +1,14 @@
+-- Log of received Stripe webhook events, keyed by Stripe's event ID, so
+-- retried deliveries are only processed once and failures can be replayed.
+CREATE TABLE IF NOT EXISTS stripe_events (
+    id VARCHAR(255) PRIMARY KEY, -- Stripe event ID, e.g. 'evt_...'
+    type VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL, -- processing, processed, failed
+    error TEXT NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    payload TEXT NOT NULL, -- Verified event JSON, for replays
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+CREATE INDEX idx_stripe_events_status_created_at ON stripe_events(status, created_at);
@@ -0,0 +1,18 @@
+-- One commission per paid invoice of a referred user, and what has been
+-- clawed back from it after refunds or disputes.
+CREATE TABLE IF NOT EXISTS referral_commissions (
+    id BIGSERIAL PRIMARY KEY,
+    referrer_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    referred_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invoice_id VARCHAR(255) UNIQUE NOT NULL,
+    charge_id VARCHAR(255),
+    transfer_id VARCHAR(255),
+    amount_cents BIGINT NOT NULL,
+    reversed_cents BIGINT NOT NULL DEFAULT 0,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(20) NOT NULL, -- paid, partially_reversed, reversed
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_referral_commissions_referrer_user_id ON referral_commissions(referrer_user_id);
+CREATE INDEX idx_referral_commissions_charge_id ON referral_commissions(charge_id);
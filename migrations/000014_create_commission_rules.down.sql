@@ -0,0 +1,4 @@
+ALTER TABLE referral_commissions
+    DROP COLUMN IF EXISTS rate_bps;
+
+DROP TABLE IF EXISTS commission_rules;
@@ -0,0 +1,24 @@
+-- Referral commission rules. Rows without a referrer are the global tiers;
+-- rows with one override them for that referrer. Within a set, the rule with
+-- the highest min_active_referrals the referrer has reached applies.
+CREATE TABLE IF NOT EXISTS commission_rules (
+    id BIGSERIAL PRIMARY KEY,
+    referrer_user_id BIGINT REFERENCES users(id) ON DELETE CASCADE, -- NULL for global rules
+    rate_bps INT NOT NULL, -- Basis points of the invoice, e.g. 2000 = 20%
+    min_active_referrals INT NOT NULL DEFAULT 0, -- Tier threshold: referred users with an active subscription
+    window_months INT NOT NULL DEFAULT 0, -- Months of a referred user's payments that earn commission; 0 = forever
+    min_payout_cents BIGINT NOT NULL DEFAULT 0, -- Pending commissions are held until they reach this
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE NULLS NOT DISTINCT (referrer_user_id, min_active_referrals)
+);
+
+-- The previous hardcoded behaviour: 20% of every invoice, paid immediately.
+INSERT INTO commission_rules (referrer_user_id, rate_bps, min_active_referrals, window_months, min_payout_cents)
+VALUES (NULL, 2000, 0, 0, 0);
+
+-- Commissions record the rate they were earned at, and may now be held as
+-- pending until the referrer's payout threshold is reached.
+ALTER TABLE referral_commissions
+    ADD COLUMN rate_bps INT NOT NULL DEFAULT 2000;
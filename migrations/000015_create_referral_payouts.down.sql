@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_referral_commissions_payout_id;
+
+ALTER TABLE referral_commissions
+    DROP COLUMN IF EXISTS payout_id;
+
+DROP TABLE IF EXISTS referral_payouts;
@@ -0,0 +1,28 @@
+-- Batched referral payouts. Commissions accrue as pending and the payout job
+-- groups each referrer's pending commissions into one payout (and one
+-- transfer) per period.
+CREATE TABLE IF NOT EXISTS referral_payouts (
+    id BIGSERIAL PRIMARY KEY,
+    referrer_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL, -- Start of the payout run that created it
+    currency VARCHAR(3) NOT NULL,
+    amount_cents BIGINT NOT NULL,
+    commission_count INT NOT NULL,
+    transfer_id VARCHAR(255),
+    status VARCHAR(20) NOT NULL, -- pending, paid, retrying, failed, cancelled
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (referrer_user_id, currency, period_start)
+);
+
+CREATE INDEX idx_referral_payouts_status ON referral_payouts(status, next_attempt_at);
+
+ALTER TABLE referral_commissions
+    ADD COLUMN payout_id BIGINT REFERENCES referral_payouts(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_referral_commissions_payout_id ON referral_commissions(payout_id);
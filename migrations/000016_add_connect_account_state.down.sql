@@ -0,0 +1,7 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS referral_code_active,
+    DROP COLUMN IF EXISTS connect_synced_at,
+    DROP COLUMN IF EXISTS connect_requirements,
+    DROP COLUMN IF EXISTS connect_details_submitted,
+    DROP COLUMN IF EXISTS connect_payouts_enabled,
+    DROP COLUMN IF EXISTS connect_charges_enabled;
@@ -0,0 +1,12 @@
+-- Stripe Connect account state, cached from account.updated webhooks so
+-- requests don't have to fetch the account from Stripe.
+ALTER TABLE users
+    ADD COLUMN connect_charges_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN connect_payouts_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN connect_details_submitted BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN connect_requirements JSONB, -- Outstanding requirements, see models.ConnectRequirements
+    ADD COLUMN connect_synced_at TIMESTAMP WITH TIME ZONE, -- Time of the Stripe event last applied
+    ADD COLUMN referral_code_active BOOLEAN NOT NULL DEFAULT FALSE; -- False while payouts are disabled
+
+-- Codes could only be set with payouts enabled, so existing ones start active.
+UPDATE users SET referral_code_active = TRUE WHERE referral_code IS NOT NULL;
@@ -0,0 +1,14 @@
+-- Visits to referral links, and the signups and first payments they led to.
+CREATE TABLE IF NOT EXISTS referral_clicks (
+    id BIGSERIAL PRIMARY KEY,
+    referrer_user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token VARCHAR(64) UNIQUE NOT NULL, -- Carried through signup in a cookie and the OAuth state
+    ip_hash VARCHAR(64) NOT NULL DEFAULT '', -- HMAC-SHA256 of the IP with REFERRAL_IP_SALT; empty if unset
+    user_agent TEXT NOT NULL DEFAULT '',
+    landing_url TEXT NOT NULL DEFAULT '',
+    signed_up_user_id BIGINT UNIQUE REFERENCES users(id) ON DELETE SET NULL,
+    signed_up_at TIMESTAMP WITH TIME ZONE,
+    converted_at TIMESTAMP WITH TIME ZONE, -- First paid invoice of the signed up user
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_referral_clicks_referrer_created ON referral_clicks(referrer_user_id, created_at);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_series_deleted_at;
+
+ALTER TABLE series
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+-- Deleted series are kept, so their videos still count towards the owner's
+-- monthly quota.
+ALTER TABLE series
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_series_deleted_at ON series(deleted_at);
@@ -0,0 +1,12 @@
+-- When a referred user first paid. Their referrer's commission window starts
+-- here, whether or not that payment earned a commission.
+ALTER TABLE users
+    ADD COLUMN referral_converted_at TIMESTAMP WITH TIME ZONE;
+
+-- Existing referred users start from their converted click, or failing that
+-- their first commission.
+UPDATE users SET referral_converted_at = COALESCE(
+    (SELECT MIN(converted_at) FROM referral_clicks WHERE signed_up_user_id = users.id),
+    (SELECT MIN(created_at) FROM referral_commissions WHERE referred_user_id = users.id)
+)
+WHERE referred_by_user_id IS NOT NULL;
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// CommissionRule sets the referral commission for a tier of referrers. Rules
+// without a ReferrerUserID apply to everyone; rules with one override them
+// for that referrer.
+type CommissionRule struct {
+	ID                 uint      `gorm:"primaryKey" json:"id"`
+	ReferrerUserID     *uint     `gorm:"index" json:"referrer_user_id,omitempty"`
+	RateBps            int       `gorm:"not null" json:"rate_bps"`                       // 2000 = 20%
+	MinActiveReferrals int       `gorm:"not null;default:0" json:"min_active_referrals"` // Tier threshold
+	WindowMonths       int       `gorm:"not null;default:0" json:"window_months"`        // 0 = no limit
+	MinPayoutCents     int64     `gorm:"not null;default:0" json:"min_payout_cents"`
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (CommissionRule) TableName() string {
+	return "commission_rules"
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// LLMUsage records the tokens and cost of a single LLM call.
+type LLMUsage struct {
+	ID               uint      `gorm:"primaryKey" json:"id"`
+	UserID           uint      `gorm:"not null;index" json:"user_id"`
+	SeriesID         *uint     `gorm:"index" json:"series_id,omitempty"`
+	VideoID          *uint     `gorm:"index" json:"video_id,omitempty"`
+	Step             string    `gorm:"size:50;not null" json:"step"`
+	Model            string    `gorm:"size:100;not null" json:"model"`
+	PromptTokens     int64     `gorm:"not null;default:0" json:"prompt_tokens"`
+	CompletionTokens int64     `gorm:"not null;default:0" json:"completion_tokens"`
+	LatencyMs        int64     `gorm:"not null;default:0" json:"latency_ms"`
+	CostMicros       int64     `gorm:"not null;default:0" json:"cost_micros"` // USD millionths
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func (LLMUsage) TableName() string {
+	return "llm_usage"
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// ReferralClick is one visit to a referral link. It is linked to the user who
+// signed up from it, and marked converted when that user first pays.
+type ReferralClick struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	ReferrerUserID uint       `gorm:"not null;index" json:"referrer_user_id"`
+	Token          string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	IPHash         string     `gorm:"column:ip_hash;size:64;not null;default:''" json:"-"`
+	UserAgent      string     `gorm:"not null;default:''" json:"user_agent"`
+	LandingURL     string     `gorm:"column:landing_url;not null;default:''" json:"landing_url"`
+	SignedUpUserID *uint      `gorm:"uniqueIndex" json:"signed_up_user_id,omitempty"`
+	SignedUpAt     *time.Time `json:"signed_up_at,omitempty"`
+	ConvertedAt    *time.Time `json:"converted_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (ReferralClick) TableName() string {
+	return "referral_clicks"
+}
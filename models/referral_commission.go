@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Referral commission statuses.
+const (
+	CommissionPending           = "pending" // Earned, waiting to be paid out
+	CommissionPaid              = "paid"
+	CommissionPartiallyReversed = "partially_reversed"
+	CommissionReversed          = "reversed"
+)
+
+// ReferralCommission is the commission earned by a referrer for one invoice
+// of a user they referred. It is paid as part of a ReferralPayout.
+type ReferralCommission struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	ReferrerUserID uint      `gorm:"not null;index" json:"referrer_user_id"`
+	ReferredUserID uint      `gorm:"not null" json:"referred_user_id"`
+	InvoiceID      string    `gorm:"uniqueIndex;not null" json:"invoice_id"`
+	ChargeID       string    `gorm:"index" json:"charge_id,omitempty"`
+	TransferID     string    `json:"transfer_id,omitempty"`
+	PayoutID       *uint     `gorm:"index" json:"payout_id,omitempty"`
+	AmountCents    int64     `gorm:"not null" json:"amount_cents"`
+	ReversedCents  int64     `gorm:"not null;default:0" json:"reversed_cents"`
+	Currency       string    `gorm:"size:3;not null" json:"currency"`
+	RateBps        int       `gorm:"not null" json:"rate_bps"`
+	Status         string    `gorm:"size:20;not null" json:"status"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (ReferralCommission) TableName() string {
+	return "referral_commissions"
+}
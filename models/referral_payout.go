@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Referral payout statuses.
+const (
+	PayoutPending   = "pending"   // Created, transfer not attempted yet
+	PayoutPaid      = "paid"      // Transferred
+	PayoutRetrying  = "retrying"  // Transfer failed, will be retried at NextAttemptAt
+	PayoutFailed    = "failed"    // Transfer failed for good; needs attention
+	PayoutCancelled = "cancelled" // Its commissions were reversed before it was paid
+)
+
+// ReferralPayout is one transfer of a referrer's pending commissions.
+type ReferralPayout struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	ReferrerUserID  uint       `gorm:"not null;index" json:"referrer_user_id"`
+	PeriodStart     time.Time  `gorm:"not null" json:"period_start"`
+	Currency        string     `gorm:"size:3;not null" json:"currency"`
+	AmountCents     int64      `gorm:"not null" json:"amount_cents"`
+	CommissionCount int        `gorm:"not null" json:"commission_count"`
+	TransferID      *string    `json:"transfer_id,omitempty"`
+	Status          string     `gorm:"size:20;not null" json:"status"`
+	Attempts        int        `gorm:"not null;default:0" json:"attempts"`
+	LastError       string     `gorm:"not null;default:''" json:"last_error,omitempty"`
+	NextAttemptAt   *time.Time `json:"next_attempt_at,omitempty"`
+	PaidAt          *time.Time `json:"paid_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func (ReferralPayout) TableName() string {
+	return "referral_payouts"
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type Series struct {
@@ -15,6 +17,21 @@ type Series struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 
+	// Deleted series are soft-deleted so their videos keep counting towards
+	// the monthly quota.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	// Posting schedule. If ScheduleCron is set, one video is generated per
+	// firing; otherwise PostsPerDay videos are spaced across the window.
+	ScheduleCron string   `gorm:"not null;default:''" json:"schedule_cron"`
+	WindowStart  string   `gorm:"not null;default:'09:00'" json:"window_start"` // HH:MM in TimeZone
+	WindowEnd    string   `gorm:"not null;default:'21:00'" json:"window_end"`
+	TimeZone     string   `gorm:"not null;default:'UTC'" json:"time_zone"`
+	DaysOfWeek   []string `gorm:"serializer:json;not null" json:"days_of_week"` // e.g. ["mon","wed"]; empty means every day
+
+	// Creative identity applied to every generated video.
+	Style StyleProfile `gorm:"embedded;embeddedPrefix:style_" json:"style"`
+
 	// Video count (computed field, not persisted)
 	VideoCount int `gorm:"-" json:"video_count"`
 }
@@ -22,3 +39,14 @@ type Series struct {
 func (Series) TableName() string {
 	return "series"
 }
+
+// StyleProfile describes how a series' videos look and sound. Empty fields
+// fall back to the generator's defaults.
+type StyleProfile struct {
+	VisualStyle     string   `gorm:"not null;default:''" json:"visual_style"`     // e.g. "cinematic, 4k, hyperrealistic"
+	ColorGrading    string   `gorm:"not null;default:''" json:"color_grading"`    // e.g. "warm film"
+	ToneOfVoice     string   `gorm:"not null;default:''" json:"tone_of_voice"`    // e.g. "playful and curious"
+	TargetAudience  string   `gorm:"not null;default:''" json:"target_audience"`  // e.g. "teenage gamers"
+	NarratorPersona string   `gorm:"not null;default:''" json:"narrator_persona"` // e.g. "a grizzled sea captain"
+	BannedTopics    []string `gorm:"serializer:json;not null" json:"banned_topics"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Stripe event processing statuses.
+const (
+	StripeEventProcessing = "processing"
+	StripeEventProcessed  = "processed"
+	StripeEventFailed     = "failed"
+)
+
+// StripeEvent records a received Stripe webhook event and the outcome of
+// processing it.
+type StripeEvent struct {
+	ID          string     `gorm:"primaryKey;size:255" json:"id"` // Stripe event ID
+	Type        string     `gorm:"size:100;not null" json:"type"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	Error       string     `gorm:"type:text;not null;default:''" json:"error,omitempty"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	Payload     string     `gorm:"type:text;not null" json:"-"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	ProcessedAt *time.Time `json:"processed_at,omitempty"`
+}
+
+func (StripeEvent) TableName() string {
+	return "stripe_events"
+}
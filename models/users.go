@@ -30,12 +30,23 @@ type User struct {
 	StripeConnectAccountID *string    `gorm:"uniqueIndex" json:"stripe_connect_account_id,omitempty"`
 	SubscriptionStatus     string     `gorm:"default:free" json:"subscription_status"`
 	SubscriptionEndsAt     *time.Time `json:"subscription_ends_at,omitempty"`
+	StripeSubscriptionID   *string    `gorm:"uniqueIndex" json:"-"`
+	Plan                   string     `gorm:"not null;default:free" json:"plan"` // See the plans package
+
+	// Stripe Connect account state, synced from account.updated webhooks
+	ConnectChargesEnabled   bool                 `gorm:"not null;default:false" json:"connect_charges_enabled"`
+	ConnectPayoutsEnabled   bool                 `gorm:"not null;default:false" json:"connect_payouts_enabled"`
+	ConnectDetailsSubmitted bool                 `gorm:"not null;default:false" json:"connect_details_submitted"`
+	ConnectRequirements     *ConnectRequirements `gorm:"serializer:json" json:"connect_requirements,omitempty"`
+	ConnectSyncedAt         *time.Time           `json:"connect_synced_at,omitempty"`
 
 	// Referral fields
-	ReferralCode          *string `gorm:"uniqueIndex" json:"referral_code,omitempty"`
-	ReferredByUserID      *uint   `json:"referred_by_user_id,omitempty"`
-	ReferredByUser        *User   `gorm:"foreignKey:ReferredByUserID" json:"referred_by,omitempty"`
-	ReferralEarningsCents int64   `gorm:"default:0" json:"referral_earnings_cents"`
+	ReferralCode          *string    `gorm:"uniqueIndex" json:"referral_code,omitempty"`
+	ReferralCodeActive    bool       `gorm:"not null;default:false" json:"referral_code_active"` // Off while payouts are disabled
+	ReferredByUserID      *uint      `json:"referred_by_user_id,omitempty"`
+	ReferredByUser        *User      `gorm:"foreignKey:ReferredByUserID" json:"referred_by,omitempty"`
+	ReferralEarningsCents int64      `gorm:"default:0" json:"referral_earnings_cents"`
+	ReferralConvertedAt   *time.Time `json:"referral_converted_at,omitempty"` // First payment after being referred; starts the commission window
 
 	// Timestamps
 	CreatedAt   time.Time      `json:"created_at"`
@@ -65,6 +76,16 @@ func (u *User) CanEarnReferrals() bool {
 	return u.StripeConnectAccountID != nil && *u.StripeConnectAccountID != ""
 }
 
+// ConnectRequirements is what Stripe still needs from a Connect account
+// holder before it can be fully enabled.
+type ConnectRequirements struct {
+	CurrentlyDue    []string   `json:"currently_due,omitempty"`
+	PastDue         []string   `json:"past_due,omitempty"`
+	EventuallyDue   []string   `json:"eventually_due,omitempty"`
+	DisabledReason  string     `json:"disabled_reason,omitempty"`
+	CurrentDeadline *time.Time `json:"current_deadline,omitempty"`
+}
+
 // CreateUserFromGoogle creates a new user from Google OAuth data
 type GoogleUserInfo struct {
 	ID            string `json:"id"`
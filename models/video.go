@@ -12,9 +12,32 @@ type Video struct {
 	Status    string    `gorm:"default:'pending'" json:"status"`
 	CreatedAt time.Time `json:"created_at"`
 
+	// Structured parts of the voiceover. Script holds the assembled text and
+	// the per-scene lines live on VideoScene.Narration.
+	Hook         string `gorm:"type:text;not null;default:''" json:"hook,omitempty"`
+	CallToAction string `gorm:"type:text;not null;default:''" json:"call_to_action,omitempty"`
+
+	// Versions of the prompt templates used for each step, e.g. "v2".
+	TitlePromptVersion  string `gorm:"size:20;not null;default:''" json:"title_prompt_version"`
+	ScenesPromptVersion string `gorm:"size:20;not null;default:''" json:"scenes_prompt_version"`
+	ScriptPromptVersion string `gorm:"size:20;not null;default:''" json:"script_prompt_version"`
+
 	Scenes []VideoScene `gorm:"foreignKey:VideoID" json:"scenes,omitempty"` //
 }
 
 func (Video) TableName() string {
 	return "seriesvideos"
 }
+
+// InFlightVideoStatuses are the statuses of videos that are queued for or
+// going through the generation pipeline.
+var InFlightVideoStatuses = []string{
+	"pending",
+	"processing_title",
+	"pending_scenes",
+	"processing_scenes",
+	"pending_script",
+	"processing_script",
+	"pending_render",
+	"rendering",
+}
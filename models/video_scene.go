@@ -10,6 +10,14 @@ type VideoScene struct {
 	Prompt      string    `gorm:"type:text" json:"prompt"`
 	Duration    float32   `json:"duration"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// Voiceover spoken over this scene, including the hook on the first scene
+	// and the call to action on the last.
+	Narration          string `gorm:"type:text;not null;default:''" json:"narration,omitempty"`
+	NarrationWordCount int    `gorm:"not null;default:0" json:"narration_word_count"`
+
+	// Version of the prompt template that generated Prompt, e.g. "v2".
+	PromptVersion string `gorm:"size:20;not null;default:''" json:"prompt_version"`
 }
 
 func (VideoScene) TableName() string {
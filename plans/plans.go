@@ -0,0 +1,81 @@
+package plans
+
+import (
+	"strings"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/processing"
+)
+
+// ---
+// PLANS AND ENTITLEMENTS
+// ---
+
+// Plan names.
+const (
+	Free = "free"
+	Pro  = "pro"
+)
+
+// Entitlements are the limits that come with a plan.
+type Entitlements struct {
+	Plan              string   `json:"plan"`
+	MaxSeries         int      `json:"max_series"`           // Active series; paused ones don't count
+	MaxPostsPerDay    int      `json:"max_posts_per_day"`    // Per series
+	MaxVideosPerMonth int      `json:"max_videos_per_month"` // Across all series, per calendar month (UTC)
+	AllowedModels     []string `json:"allowed_models"`       // Most preferred first
+}
+
+var catalog = map[string]Entitlements{
+	Free: {
+		Plan:              Free,
+		MaxSeries:         1,
+		MaxPostsPerDay:    1,
+		MaxVideosPerMonth: 30,
+		AllowedModels:     []string{"gpt-4o-mini"},
+	},
+	Pro: {
+		Plan:              Pro,
+		MaxSeries:         10,
+		MaxPostsPerDay:    3,
+		MaxVideosPerMonth: 300,
+		AllowedModels:     []string{"gpt-4o-mini", "gpt-4o", "gpt-4.1-mini", "gpt-4.1"},
+	},
+}
+
+// For returns the entitlements of a user's current plan. Users without an
+// active subscription get the free plan.
+func For(user models.User) Entitlements {
+	if !user.IsSubscribed() {
+		return catalog[Free]
+	}
+	if ent, ok := catalog[user.Plan]; ok && user.Plan != Free {
+		return ent
+	}
+	// Subscribed before plans were tracked.
+	return catalog[Pro]
+}
+
+// AllowsModel reports whether the plan may use a model. Models without a
+// price (local or fake models) cost nothing and are always allowed. Dated
+// model names such as "gpt-4o-2024-08-06" match their base name.
+func (e Entitlements) AllowsModel(model string) bool {
+	if _, priced := processing.PriceFor(model); !priced {
+		return true
+	}
+	for _, allowed := range e.AllowedModels {
+		if model == allowed || strings.HasPrefix(model, allowed+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// ChooseModel returns preferred if the plan allows it, otherwise the plan's
+// first allowed model.
+func (e Entitlements) ChooseModel(preferred string) string {
+	if e.AllowsModel(preferred) || len(e.AllowedModels) == 0 {
+		return preferred
+	}
+	return e.AllowedModels[0]
+}
@@ -0,0 +1,33 @@
+package plans
+
+import (
+	"os"
+	"strings"
+)
+
+// Paid lists the plans that can be bought through Stripe Checkout.
+var Paid = []string{Pro}
+
+// PriceID returns the Stripe price configured for a paid plan through
+// STRIPE_PRICE_<PLAN> (e.g. STRIPE_PRICE_PRO), or "" if there is none.
+func PriceID(plan string) string {
+	for _, p := range Paid {
+		if p == plan {
+			return os.Getenv("STRIPE_PRICE_" + strings.ToUpper(plan))
+		}
+	}
+	return ""
+}
+
+// ForPrice returns the paid plan a Stripe price belongs to.
+func ForPrice(priceID string) (string, bool) {
+	if priceID == "" {
+		return "", false
+	}
+	for _, plan := range Paid {
+		if PriceID(plan) == priceID {
+			return plan, true
+		}
+	}
+	return "", false
+}
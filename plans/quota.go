@@ -0,0 +1,159 @@
+package plans
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"gorm.io/gorm"
+)
+
+// ErrQuotaExceeded is wrapped by every quota violation.
+var ErrQuotaExceeded = errors.New("plan quota exceeded")
+
+// uncountedStatuses are video statuses that don't use up monthly quota:
+// videos that were cancelled, refused or expired before any generation ran.
+var uncountedStatuses = []string{"cancelled", "quota_exceeded", "expired"}
+
+// Quota is a user's plan together with how much of it has been used.
+type Quota struct {
+	Entitlements
+	ActiveSeries       int64      `json:"active_series"`
+	SeriesRemaining    int64      `json:"series_remaining"`
+	VideosThisMonth    int64      `json:"videos_this_month"`
+	VideosRemaining    int64      `json:"videos_remaining"`
+	PeriodStart        time.Time  `json:"period_start"`
+	PeriodEnd          time.Time  `json:"period_end"`
+	SubscriptionStatus string     `json:"subscription_status"`
+	SubscriptionEndsAt *time.Time `json:"subscription_ends_at,omitempty"`
+}
+
+// MonthStart returns the start of the quota period containing t.
+func MonthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Load returns a user and their entitlements.
+func Load(db *gorm.DB, userID uint) (*models.User, Entitlements, error) {
+	var user models.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, Entitlements{}, err
+	}
+	return &user, For(user), nil
+}
+
+// ActiveSeries counts a user's active series.
+func ActiveSeries(db *gorm.DB, userID uint) (int64, error) {
+	var count int64
+	err := db.Model(&models.Series{}).
+		Where("user_id = ? AND is_active = ?", userID, true).
+		Count(&count).Error
+	return count, err
+}
+
+// videosThisMonth scopes a query to the user's quota-counted videos created in
+// the current month. The raw join keeps videos of deleted series, so deleting
+// a series doesn't give its quota back.
+func videosThisMonth(db *gorm.DB, userID uint, now time.Time) *gorm.DB {
+	return db.Model(&models.Video{}).
+		Joins("JOIN series ON series.id = seriesvideos.series_id").
+		Where("series.user_id = ?", userID).
+		Where("seriesvideos.created_at >= ?", MonthStart(now)).
+		Where("seriesvideos.status NOT IN ?", uncountedStatuses)
+}
+
+// VideosThisMonth counts the videos a user has generated this month.
+func VideosThisMonth(db *gorm.DB, userID uint, now time.Time) (int64, error) {
+	var count int64
+	err := videosThisMonth(db, userID, now).Count(&count).Error
+	return count, err
+}
+
+// RemainingVideos returns how many more videos the user may create this month.
+func RemainingVideos(db *gorm.DB, userID uint, ent Entitlements, now time.Time) (int64, error) {
+	used, err := VideosThisMonth(db, userID, now)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := int64(ent.MaxVideosPerMonth) - used; remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// CheckVideo reports ErrQuotaExceeded if a video would go over the user's
+// monthly limit. Only videos created before it count, so when a batch
+// overshoots the limit the earliest ones win.
+func CheckVideo(db *gorm.DB, video models.Video, userID uint, ent Entitlements) error {
+	var earlier int64
+	err := videosThisMonth(db, userID, video.CreatedAt).
+		Where("seriesvideos.id < ?", video.ID).
+		Count(&earlier).Error
+	if err != nil {
+		return err
+	}
+	if earlier >= int64(ent.MaxVideosPerMonth) {
+		return fmt.Errorf("%w: %d videos per month on the %s plan", ErrQuotaExceeded, ent.MaxVideosPerMonth, ent.Plan)
+	}
+	return nil
+}
+
+// CheckNewSeries reports ErrQuotaExceeded if the user can't have another
+// active series with postsPerDay posts a day.
+func CheckNewSeries(db *gorm.DB, userID uint, ent Entitlements, postsPerDay int) error {
+	if err := CheckPostsPerDay(ent, postsPerDay); err != nil {
+		return err
+	}
+	active, err := ActiveSeries(db, userID)
+	if err != nil {
+		return err
+	}
+	if active >= int64(ent.MaxSeries) {
+		return fmt.Errorf("%w: %d active series on the %s plan", ErrQuotaExceeded, ent.MaxSeries, ent.Plan)
+	}
+	return nil
+}
+
+// CheckPostsPerDay reports ErrQuotaExceeded if postsPerDay is over the plan limit.
+func CheckPostsPerDay(ent Entitlements, postsPerDay int) error {
+	if postsPerDay > ent.MaxPostsPerDay {
+		return fmt.Errorf("%w: %d posts per day on the %s plan", ErrQuotaExceeded, ent.MaxPostsPerDay, ent.Plan)
+	}
+	return nil
+}
+
+// GetQuota returns a user's plan and current usage.
+func GetQuota(db *gorm.DB, userID uint, now time.Time) (*Quota, error) {
+	user, ent, err := Load(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	active, err := ActiveSeries(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	used, err := VideosThisMonth(db, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	start := MonthStart(now)
+	q := &Quota{
+		Entitlements:       ent,
+		ActiveSeries:       active,
+		VideosThisMonth:    used,
+		PeriodStart:        start,
+		PeriodEnd:          start.AddDate(0, 1, 0),
+		SubscriptionStatus: user.SubscriptionStatus,
+		SubscriptionEndsAt: user.SubscriptionEndsAt,
+	}
+	if remaining := int64(ent.MaxSeries) - active; remaining > 0 {
+		q.SeriesRemaining = remaining
+	}
+	if remaining := int64(ent.MaxVideosPerMonth) - used; remaining > 0 {
+		q.VideosRemaining = remaining
+	}
+	return q, nil
+}
@@ -0,0 +1,124 @@
+package plans
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns an in-memory database with a single user.
+func newTestDB(t *testing.T) (*gorm.DB, models.User) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Series{}, &models.Video{}); err != nil {
+		t.Fatal(err)
+	}
+	user := models.User{GoogleID: "g1", Email: "a@example.com"}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db, user
+}
+
+func createSeries(t *testing.T, db *gorm.DB, userID uint) models.Series {
+	t.Helper()
+	series := models.Series{UserID: userID, Title: "Space", IsActive: true}
+	if err := db.Create(&series).Error; err != nil {
+		t.Fatal(err)
+	}
+	return series
+}
+
+func createVideo(t *testing.T, db *gorm.DB, seriesID uint, status string, createdAt time.Time) models.Video {
+	t.Helper()
+	video := models.Video{SeriesID: seriesID, Status: status, CreatedAt: createdAt}
+	if err := db.Create(&video).Error; err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+func TestVideosThisMonth(t *testing.T) {
+	db, user := newTestDB(t)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	series := createSeries(t, db, user.ID)
+
+	createVideo(t, db, series.ID, "completed", now.Add(-time.Hour))
+	createVideo(t, db, series.ID, "processing_scenes", MonthStart(now))
+	createVideo(t, db, series.ID, "failed", now)
+	// Not counted: last month, or never generated
+	createVideo(t, db, series.ID, "completed", MonthStart(now).Add(-time.Second))
+	for _, status := range uncountedStatuses {
+		createVideo(t, db, series.ID, status, now)
+	}
+	// Another user's video
+	other := models.User{GoogleID: "g2", Email: "b@example.com"}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatal(err)
+	}
+	createVideo(t, db, createSeries(t, db, other.ID).ID, "completed", now)
+
+	used, err := VideosThisMonth(db, user.ID, now)
+	if err != nil {
+		t.Fatalf("VideosThisMonth: %v", err)
+	}
+	if used != 3 {
+		t.Errorf("VideosThisMonth = %d, want 3", used)
+	}
+}
+
+func TestVideosThisMonthCountsDeletedSeries(t *testing.T) {
+	db, user := newTestDB(t)
+	now := time.Now().UTC()
+	series := createSeries(t, db, user.ID)
+	createVideo(t, db, series.ID, "completed", now)
+	createVideo(t, db, series.ID, "pending", now)
+
+	before, err := VideosThisMonth(db, user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(&series).Error; err != nil {
+		t.Fatal(err)
+	}
+	after, err := VideosThisMonth(db, user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 2 || after != before {
+		t.Errorf("VideosThisMonth = %d before deleting the series and %d after, want 2 both times", before, after)
+	}
+
+	// The deleted series no longer takes up a series slot
+	if active, _ := ActiveSeries(db, user.ID); active != 0 {
+		t.Errorf("ActiveSeries = %d after deleting the only series, want 0", active)
+	}
+}
+
+func TestCheckVideoLetsEarliestVideosThrough(t *testing.T) {
+	db, user := newTestDB(t)
+	now := time.Now().UTC()
+	series := createSeries(t, db, user.ID)
+	ent := Entitlements{Plan: Free, MaxVideosPerMonth: 2}
+
+	var videos []models.Video
+	for i := 0; i < 3; i++ {
+		videos = append(videos, createVideo(t, db, series.ID, "pending", now))
+	}
+	for i, video := range videos {
+		err := CheckVideo(db, video, user.ID, ent)
+		if i < 2 && err != nil {
+			t.Errorf("video %d: CheckVideo = %v, want it within quota", i+1, err)
+		}
+		if i == 2 && !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("video %d: CheckVideo = %v, want ErrQuotaExceeded", i+1, err)
+		}
+	}
+}
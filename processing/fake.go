@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// FakeLLM returns deterministic JSON built from the request schema, so the
+// pipeline can run without network access. The same prompt always yields the
+// same answer; different prompts yield different strings.
+type FakeLLM struct {
+	Model string
+}
+
+// NewFakeLLM creates a fake provider.
+func NewFakeLLM() *FakeLLM {
+	return &FakeLLM{Model: "fake"}
+}
+
+// DefaultModel returns the model name reported in completions.
+func (f *FakeLLM) DefaultModel() string {
+	return f.Model
+}
+
+// Complete implements LLM.
+func (f *FakeLLM) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Round-trip the schema through JSON so we can walk it generically.
+	raw, err := json.Marshal(req.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("fake LLM: invalid schema: %w", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("fake LLM: invalid schema: %w", err)
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(req.Prompt))
+	seed := fmt.Sprintf("%08x", h.Sum32())
+
+	content, err := json.Marshal(fakeValue(schema, "value", seed, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	model := req.Model
+	if model == "" {
+		model = f.Model
+	}
+	return &Completion{
+		Content:          string(content),
+		Model:            model,
+		PromptTokens:     int64(len(strings.Fields(req.Prompt))),
+		CompletionTokens: int64(len(strings.Fields(string(content)))),
+	}, nil
+}
+
+// fakeValue produces a placeholder value for a JSON schema node. index is the
+// node's 1-based position in its enclosing array, so integers such as scene
+// numbers come out as 1, 2, 3.
+func fakeValue(schema map[string]interface{}, name, seed string, index int) interface{} {
+	switch schemaType(schema) {
+	case "object":
+		obj := map[string]interface{}{}
+		props, _ := schema["properties"].(map[string]interface{})
+		for prop, sub := range props {
+			subSchema, _ := sub.(map[string]interface{})
+			obj[prop] = fakeValue(subSchema, prop, seed, index)
+		}
+		return obj
+	case "array":
+		items, _ := schema["items"].(map[string]interface{})
+		arr := make([]interface{}, 3)
+		for i := range arr {
+			arr[i] = fakeValue(items, fmt.Sprintf("%s %d", name, i+1), seed, i+1)
+		}
+		return arr
+	case "integer":
+		return index
+	case "number":
+		return 5
+	case "boolean":
+		return false
+	default:
+		return fmt.Sprintf("Fake %s %s", name, seed)
+	}
+}
+
+// schemaType returns the node's type, picking the first non-null entry when
+// the schema lists several.
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+	return "string"
+}
@@ -0,0 +1,107 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ---
+// LLM PROVIDERS
+// ---
+
+// DefaultModel is used when LLM_MODEL is not set.
+const DefaultModel = "gpt-4o-mini"
+
+// LLM is a language model that can answer a prompt with JSON matching a schema.
+type LLM interface {
+	Complete(ctx context.Context, req CompletionRequest) (*Completion, error)
+}
+
+// CompletionRequest is a single structured-output prompt.
+type CompletionRequest struct {
+	Name        string      // Schema name reported to the provider, e.g. "video_title"
+	Description string      // Short description of the expected response
+	Prompt      string      // The user message
+	Schema      interface{} // JSON schema from GenerateSchema
+	Model       string      // Optional override of the provider's default model
+}
+
+// Completion is the provider's raw JSON answer plus usage metadata.
+type Completion struct {
+	Content          string
+	Model            string
+	PromptTokens     int64
+	CompletionTokens int64
+}
+
+// NewLLMFromEnv builds the provider selected by LLM_PROVIDER:
+//   - "openai" (default): the OpenAI API, keyed by OPENAI_API_KEY
+//   - "openai_compatible": any OpenAI-compatible server at LLM_BASE_URL
+//     (e.g. a local Ollama or vLLM), keyed by LLM_API_KEY if it needs one
+//   - "fake": deterministic canned responses, for offline development
+//
+// LLM_MODEL overrides the default model for the real providers.
+func NewLLMFromEnv() (LLM, error) {
+	model := os.Getenv("LLM_MODEL")
+	if model == "" {
+		model = DefaultModel
+	}
+
+	switch provider := os.Getenv("LLM_PROVIDER"); provider {
+	case "", "openai":
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
+		}
+		return NewOpenAI(apiKey, model), nil
+	case "openai_compatible":
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("LLM_BASE_URL environment variable not set")
+		}
+		return NewOpenAICompatible(baseURL, os.Getenv("LLM_API_KEY"), model), nil
+	case "fake":
+		return NewFakeLLM(), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM_PROVIDER %q", provider)
+	}
+}
+
+// ModelOf returns the model an LLM uses when a request doesn't name one, or
+// "" if the provider doesn't say.
+func ModelOf(llm LLM) string {
+	if m, ok := llm.(interface{ DefaultModel() string }); ok {
+		return m.DefaultModel()
+	}
+	return ""
+}
+
+type modelKey struct{}
+
+// WithModel makes LLM calls made with ctx use model instead of the provider's
+// default, unless the request names its own.
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// complete sends req and decodes the JSON answer into T.
+func complete[T any](ctx context.Context, llm LLM, req CompletionRequest) (*T, error) {
+	if model, ok := ctx.Value(modelKey{}).(string); ok && req.Model == "" {
+		req.Model = model
+	}
+	completion, err := llm.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if completion.Content == "" {
+		return nil, fmt.Errorf("LLM returned empty response")
+	}
+
+	var structuredResponse T
+	if err := json.Unmarshal([]byte(completion.Content), &structuredResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM JSON response: %w\nRaw content: %s", err, completion.Content)
+	}
+	return &structuredResponse, nil
+}
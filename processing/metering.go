@@ -0,0 +1,90 @@
+package processing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+// --- Usage Metering ---
+
+// Attribution says who an LLM call is made on behalf of.
+type Attribution struct {
+	UserID   uint
+	SeriesID uint
+	VideoID  uint
+}
+
+type attributionKey struct{}
+
+// WithAttribution attaches the owner of subsequent LLM calls to ctx.
+func WithAttribution(ctx context.Context, a Attribution) context.Context {
+	return context.WithValue(ctx, attributionKey{}, a)
+}
+
+// AttributionFromContext returns the attribution set on ctx, if any.
+func AttributionFromContext(ctx context.Context) (Attribution, bool) {
+	a, ok := ctx.Value(attributionKey{}).(Attribution)
+	return a, ok
+}
+
+// UsageRecorder stores a usage record.
+type UsageRecorder func(ctx context.Context, usage models.LLMUsage) error
+
+// MeteredLLM wraps an LLM and records the tokens, latency and cost of every
+// successful call made with an attributed context. The request name is used as
+// the step.
+type MeteredLLM struct {
+	LLM    LLM
+	Record UsageRecorder
+}
+
+// NewMeteredLLM wraps llm so its usage is passed to record.
+func NewMeteredLLM(llm LLM, record UsageRecorder) *MeteredLLM {
+	return &MeteredLLM{LLM: llm, Record: record}
+}
+
+// DefaultModel returns the wrapped provider's default model.
+func (m *MeteredLLM) DefaultModel() string {
+	return ModelOf(m.LLM)
+}
+
+// Complete implements LLM.
+func (m *MeteredLLM) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	start := time.Now()
+	completion, err := m.LLM.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	a, ok := AttributionFromContext(ctx)
+	if !ok {
+		log.Printf("Unattributed LLM call for %s (%d prompt, %d completion tokens)", req.Name, completion.PromptTokens, completion.CompletionTokens)
+		return completion, nil
+	}
+
+	usage := models.LLMUsage{
+		UserID:           a.UserID,
+		Step:             req.Name,
+		Model:            completion.Model,
+		PromptTokens:     completion.PromptTokens,
+		CompletionTokens: completion.CompletionTokens,
+		LatencyMs:        time.Since(start).Milliseconds(),
+		CostMicros:       CostMicros(completion.Model, completion.PromptTokens, completion.CompletionTokens),
+	}
+	if a.SeriesID != 0 {
+		usage.SeriesID = &a.SeriesID
+	}
+	if a.VideoID != 0 {
+		usage.VideoID = &a.VideoID
+	}
+
+	// The call has already been paid for, so record it even if the task is
+	// being cancelled. A failed write must not fail the generation step.
+	if err := m.Record(context.WithoutCancel(ctx), usage); err != nil {
+		log.Printf("Error recording LLM usage for %s: %v", req.Name, err)
+	}
+	return completion, nil
+}
@@ -0,0 +1,105 @@
+package processing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// OpenAI calls the Chat Completions API with strict JSON schema output. It also
+// works against OpenAI-compatible servers via NewOpenAICompatible.
+type OpenAI struct {
+	client openai.Client
+	model  string
+}
+
+// NewOpenAI creates a provider for the OpenAI API.
+func NewOpenAI(apiKey, model string) *OpenAI {
+	return &OpenAI{
+		client: openai.NewClient(option.WithAPIKey(apiKey)),
+		model:  model,
+	}
+}
+
+// NewOpenAICompatible creates a provider for a server exposing the OpenAI API
+// at baseURL, such as a local model server. apiKey may be empty.
+func NewOpenAICompatible(baseURL, apiKey, model string) *OpenAI {
+	opts := []option.RequestOption{option.WithBaseURL(baseURL)}
+	if apiKey != "" {
+		opts = append(opts, option.WithAPIKey(apiKey))
+	} else {
+		// The SDK insists on a key; local servers ignore it.
+		opts = append(opts, option.WithAPIKey("unused"))
+	}
+	return &OpenAI{
+		client: openai.NewClient(opts...),
+		model:  model,
+	}
+}
+
+// DefaultModel returns the model used when a request doesn't name one.
+func (o *OpenAI) DefaultModel() string {
+	return o.model
+}
+
+// Complete implements LLM.
+func (o *OpenAI) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	model := req.Model
+	if model == "" {
+		model = o.model
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "structured_response"
+	}
+	description := req.Description
+	if description == "" {
+		description = "Structured data response"
+	}
+
+	schemaParam := openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        name,
+		Description: openai.String(description),
+		Schema:      req.Schema,
+		Strict:      openai.Bool(true),
+	}
+
+	chatCompletion, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Messages: []openai.ChatCompletionMessageParamUnion{
+			openai.UserMessage(req.Prompt),
+		},
+		Model: openai.ChatModel(model),
+		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: schemaParam,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("OpenAI API error: %w", err)
+	}
+
+	if len(chatCompletion.Choices) == 0 {
+		return nil, fmt.Errorf("no response from OpenAI")
+	}
+
+	content := chatCompletion.Choices[0].Message.Content
+	if content == "" {
+		return nil, fmt.Errorf("OpenAI returned empty response. Finish reason: %s", chatCompletion.Choices[0].FinishReason)
+	}
+
+	// Some compatible servers leave the model out of the response.
+	if chatCompletion.Model != "" {
+		model = chatCompletion.Model
+	}
+
+	return &Completion{
+		Content:          content,
+		Model:            model,
+		PromptTokens:     chatCompletion.Usage.PromptTokens,
+		CompletionTokens: chatCompletion.Usage.CompletionTokens,
+	}, nil
+}
@@ -0,0 +1,43 @@
+package processing
+
+import "strings"
+
+// ModelPrice is what a model costs, in USD millionths per million tokens.
+type ModelPrice struct {
+	InputPerMillion  int64
+	OutputPerMillion int64
+}
+
+// ModelPrices holds list prices for the models we use. Models reported with a
+// dated suffix (e.g. "gpt-4o-mini-2024-07-18") match their base name.
+var ModelPrices = map[string]ModelPrice{
+	"gpt-4o-mini":  {InputPerMillion: 150_000, OutputPerMillion: 600_000},
+	"gpt-4o":       {InputPerMillion: 2_500_000, OutputPerMillion: 10_000_000},
+	"gpt-4.1-nano": {InputPerMillion: 100_000, OutputPerMillion: 400_000},
+	"gpt-4.1-mini": {InputPerMillion: 400_000, OutputPerMillion: 1_600_000},
+	"gpt-4.1":      {InputPerMillion: 2_000_000, OutputPerMillion: 8_000_000},
+}
+
+// PriceFor returns the price of a model, matching the longest known prefix.
+func PriceFor(model string) (ModelPrice, bool) {
+	var best string
+	for name := range ModelPrices {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return ModelPrices[best], true
+}
+
+// CostMicros returns the cost of a completion in USD millionths, or 0 for
+// models without a known price (e.g. local or fake models).
+func CostMicros(model string, promptTokens, completionTokens int64) int64 {
+	price, ok := PriceFor(model)
+	if !ok {
+		return 0
+	}
+	return (promptTokens*price.InputPerMillion + completionTokens*price.OutputPerMillion) / 1_000_000
+}
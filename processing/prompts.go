@@ -0,0 +1,229 @@
+package processing
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+// --- Prompt Templates ---
+// Prompts live in prompts/<name>.<version>.tmpl (e.g. title.v2.tmpl) and are
+// embedded into the binary. Each name defaults to its highest version. Set
+// PROMPT_VERSION_<NAME> to pin a version ("v1", to roll back) or to split
+// traffic between versions ("v1=80,v2=20", to A/B test). The split is keyed on
+// the video ID, so every retry of a video uses the same version.
+
+// Prompt template names.
+const (
+	PromptTitle          = "title"
+	PromptSceneBreakdown = "scene_breakdown"
+	PromptScenePrompt    = "scene_prompt"
+	PromptScript         = "script"
+)
+
+//go:embed prompts/*.tmpl
+var promptFiles embed.FS
+
+// prompts is the registry of embedded templates, parsed once at startup.
+var prompts = mustLoadPrompts(promptFiles)
+
+// Prompt is one version of a named template.
+type Prompt struct {
+	Name    string
+	Version string // e.g. "v2"
+	tmpl    *template.Template
+}
+
+// promptData is the input shared by every template. Each template uses the
+// fields relevant to its step.
+type promptData struct {
+	Series         models.Series
+	Style          models.StyleProfile // Series style with defaults applied
+	VisualStyle    string
+	Guidelines     string
+	BannedTopics   string
+	VideoTitle     string
+	ExistingTitles string
+
+	SceneDescription string // scene_prompt only
+
+	SceneList  string // script only
+	FirstScene int
+	LastScene  int
+}
+
+// newPromptData fills the fields every step shares.
+func newPromptData(series models.Series, videoTitle string) promptData {
+	style := resolveStyle(series)
+	return promptData{
+		Series:       series,
+		Style:        style,
+		VisualStyle:  visualStylePrompt(series),
+		Guidelines:   audienceGuidelines(series),
+		BannedTopics: bannedTopics(style),
+		VideoTitle:   videoTitle,
+	}
+}
+
+// Render executes the template.
+func (p *Prompt) Render(data promptData) (string, error) {
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt %s.%s: %w", p.Name, p.Version, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// PromptRegistry holds every version of every template.
+type PromptRegistry struct {
+	versions map[string]map[string]*Prompt // name -> version -> prompt
+	latest   map[string]string
+}
+
+// mustLoadPrompts parses the embedded templates. They ship with the binary, so
+// a broken one is a programming error.
+func mustLoadPrompts(fsys fs.FS) *PromptRegistry {
+	r, err := LoadPrompts(fsys)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// LoadPrompts parses all *.tmpl files in fsys's prompts directory.
+func LoadPrompts(fsys fs.FS) (*PromptRegistry, error) {
+	paths, err := fs.Glob(fsys, "prompts/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &PromptRegistry{
+		versions: make(map[string]map[string]*Prompt),
+		latest:   make(map[string]string),
+	}
+	for _, path := range paths {
+		base := strings.TrimSuffix(path[strings.LastIndex(path, "/")+1:], ".tmpl")
+		dot := strings.LastIndex(base, ".")
+		if dot < 0 || versionNumber(base[dot+1:]) < 0 {
+			return nil, fmt.Errorf("prompt file %s must be named <name>.v<N>.tmpl", path)
+		}
+		name, version := base[:dot], base[dot+1:]
+
+		raw, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(base).Option("missingkey=error").Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prompt %s: %w", path, err)
+		}
+
+		if r.versions[name] == nil {
+			r.versions[name] = make(map[string]*Prompt)
+		}
+		r.versions[name][version] = &Prompt{Name: name, Version: version, tmpl: tmpl}
+		if versionNumber(version) > versionNumber(r.latest[name]) {
+			r.latest[name] = version
+		}
+	}
+	return r, nil
+}
+
+// Get returns a specific version of a template.
+func (r *PromptRegistry) Get(name, version string) (*Prompt, error) {
+	p, ok := r.versions[name][version]
+	if !ok {
+		return nil, fmt.Errorf("unknown prompt %s.%s", name, version)
+	}
+	return p, nil
+}
+
+// Select picks the version of a template to use for the given video, honouring
+// any PROMPT_VERSION_<NAME> override.
+func (r *PromptRegistry) Select(name string, videoID uint) (*Prompt, error) {
+	if _, ok := r.versions[name]; !ok {
+		return nil, fmt.Errorf("unknown prompt %s", name)
+	}
+
+	rollout := os.Getenv("PROMPT_VERSION_" + strings.ToUpper(name))
+	if rollout == "" {
+		return r.Get(name, r.latest[name])
+	}
+	version, err := pickVersion(rollout, fmt.Sprintf("%s:%d", name, videoID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROMPT_VERSION_%s: %w", strings.ToUpper(name), err)
+	}
+	return r.Get(name, version)
+}
+
+// pickVersion chooses from a rollout like "v2" or "v1=80,v2=20", hashing key
+// so the same key always gets the same version.
+func pickVersion(rollout, key string) (string, error) {
+	type weighted struct {
+		version string
+		weight  uint32
+	}
+	var options []weighted
+	var total uint32
+	for _, part := range strings.Split(rollout, ",") {
+		version, weightStr, hasWeight := strings.Cut(strings.TrimSpace(part), "=")
+		weight := uint64(1)
+		if hasWeight {
+			var err error
+			if weight, err = strconv.ParseUint(weightStr, 10, 16); err != nil {
+				return "", fmt.Errorf("bad weight in %q", part)
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		options = append(options, weighted{version, uint32(weight)})
+		total += uint32(weight)
+	}
+	if total == 0 {
+		return "", fmt.Errorf("no versions with a positive weight")
+	}
+	sort.Slice(options, func(i, j int) bool { return options[i].version < options[j].version })
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	bucket := h.Sum32() % total
+	for _, o := range options {
+		if bucket < o.weight {
+			return o.version, nil
+		}
+		bucket -= o.weight
+	}
+	return options[len(options)-1].version, nil
+}
+
+// versionNumber parses "v<N>", returning -1 for anything else.
+func versionNumber(version string) int {
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil || !strings.HasPrefix(version, "v") || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// renderPrompt selects and renders a template for a video.
+func renderPrompt(name string, videoID uint, data promptData) (string, string, error) {
+	p, err := prompts.Select(name, videoID)
+	if err != nil {
+		return "", "", err
+	}
+	text, err := p.Render(data)
+	if err != nil {
+		return "", "", err
+	}
+	return text, p.Version, nil
+}
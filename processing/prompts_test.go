@@ -0,0 +1,150 @@
+package processing
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+// testRegistry loads a title prompt with versions v1, v2 and v10.
+func testRegistry(t *testing.T) *PromptRegistry {
+	t.Helper()
+	r, err := LoadPrompts(fstest.MapFS{
+		"prompts/title.v1.tmpl":  {Data: []byte("v1 {{.Series.Title}}")},
+		"prompts/title.v2.tmpl":  {Data: []byte("v2 {{.Series.Title}}")},
+		"prompts/title.v10.tmpl": {Data: []byte("v10 {{.Series.Title}}")},
+	})
+	if err != nil {
+		t.Fatalf("LoadPrompts: %v", err)
+	}
+	return r
+}
+
+func TestLoadPromptsRejectsBadFiles(t *testing.T) {
+	tests := map[string]string{
+		"prompts/title.tmpl":     "no version",
+		"prompts/title.two.tmpl": "bad version",
+		"prompts/title.v1.tmpl":  "{{.Series.Title",
+	}
+	for path, data := range tests {
+		if _, err := LoadPrompts(fstest.MapFS{path: {Data: []byte(data)}}); err == nil {
+			t.Errorf("LoadPrompts accepted %s containing %q", path, data)
+		}
+	}
+}
+
+func TestEmbeddedPromptsRender(t *testing.T) {
+	data := newPromptData(models.Series{Title: "Space"}, "Black holes")
+	for _, name := range []string{PromptTitle, PromptSceneBreakdown, PromptScenePrompt, PromptScript} {
+		p, err := prompts.Select(name, 1)
+		if err != nil {
+			t.Fatalf("Select(%s): %v", name, err)
+		}
+		text, err := p.Render(data)
+		if err != nil {
+			t.Errorf("Render(%s): %v", name, err)
+		} else if !strings.Contains(text, "Space") {
+			t.Errorf("%s prompt does not mention the series:\n%s", name, text)
+		}
+	}
+}
+
+func TestSelectDefaultsToLatestVersion(t *testing.T) {
+	r := testRegistry(t)
+	p, err := r.Select(PromptTitle, 1)
+	if err != nil {
+		t.Fatalf("Select: %v", err)
+	}
+	// Versions compare numerically, so v10 is newer than v2
+	if p.Version != "v10" {
+		t.Errorf("selected %s, want v10", p.Version)
+	}
+	if _, err := r.Select("thumbnail", 1); err == nil {
+		t.Error("Select accepted an unknown prompt")
+	}
+}
+
+func TestSelectHonoursPinnedVersion(t *testing.T) {
+	r := testRegistry(t)
+	t.Setenv("PROMPT_VERSION_TITLE", "v1")
+	for id := uint(1); id <= 20; id++ {
+		p, err := r.Select(PromptTitle, id)
+		if err != nil {
+			t.Fatalf("Select: %v", err)
+		}
+		if p.Version != "v1" {
+			t.Fatalf("video %d got %s, want pinned v1", id, p.Version)
+		}
+	}
+
+	t.Setenv("PROMPT_VERSION_TITLE", "v3")
+	if _, err := r.Select(PromptTitle, 1); err == nil {
+		t.Error("Select accepted a pinned version that doesn't exist")
+	}
+}
+
+func TestSelectSplitsTrafficStablyPerVideo(t *testing.T) {
+	r := testRegistry(t)
+	t.Setenv("PROMPT_VERSION_TITLE", "v1=80,v2=20")
+
+	counts := map[string]int{}
+	for id := uint(1); id <= 1000; id++ {
+		p, err := r.Select(PromptTitle, id)
+		if err != nil {
+			t.Fatalf("Select: %v", err)
+		}
+		counts[p.Version]++
+
+		// Retries of a video get the same version
+		again, _ := r.Select(PromptTitle, id)
+		if again.Version != p.Version {
+			t.Fatalf("video %d got %s then %s", id, p.Version, again.Version)
+		}
+	}
+	if counts["v1"] < 720 || counts["v1"] > 880 || counts["v1"]+counts["v2"] != 1000 {
+		t.Errorf("split = %v, want about 800 v1 and 200 v2", counts)
+	}
+}
+
+func TestPickVersion(t *testing.T) {
+	tests := []struct {
+		rollout string
+		want    string // Expected for every key; empty if keys should split
+		wantErr bool
+	}{
+		{rollout: "v2", want: "v2"},
+		{rollout: " v2 ", want: "v2"},
+		{rollout: "v1=0,v2=5", want: "v2"},
+		{rollout: "v2=1,v1=0", want: "v2"},
+		{rollout: "v1=1,v2=1"},
+		{rollout: "v1=0", wantErr: true},
+		{rollout: "v1=abc", wantErr: true},
+		{rollout: "v1=-1,v2=1", wantErr: true},
+		{rollout: "v1=70000", wantErr: true},
+	}
+	for _, tt := range tests {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			got, err := pickVersion(tt.rollout, fmt.Sprintf("title:%d", i))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("pickVersion(%q) = %s, want an error", tt.rollout, got)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("pickVersion(%q): %v", tt.rollout, err)
+			}
+			seen[got] = true
+		}
+		if tt.want != "" && (len(seen) != 1 || !seen[tt.want]) {
+			t.Errorf("pickVersion(%q) picked %v, want only %s", tt.rollout, seen, tt.want)
+		}
+		if tt.want == "" && !tt.wantErr && len(seen) != 2 {
+			t.Errorf("pickVersion(%q) picked %v, want both versions", tt.rollout, seen)
+		}
+	}
+}
@@ -2,30 +2,192 @@ package processing
 
 import (
 	"context"
-	"fmt" // Import fmt for new placeholder message
+	"fmt"
+	"math"
+	"sort"
+	"strings"
 
 	"github.com/drewmudry/instashorts-api/models"
 )
 
-// GenerateScript is a placeholder for your script generation logic.
-// It fulfills the function call from the worker handler.
-func GenerateScript(ctx context.Context, video models.Video, series models.Series) (string, error) {
+// --- Script Generation Structs and Logic ---
 
-	// TODO: Add your real script generation logic here.
-	// This logic should now take the video.Title and video.Scenes as input
-	// to generate a narrative script (dialogue/voiceover) that aligns with the visual scenes.
+const (
+	// WordsPerSecond is the narration pace used to turn scene durations into
+	// word budgets. 2.5 words/s (150 wpm) is a brisk but clear voiceover.
+	WordsPerSecond = 2.5
 
-	scenesSummary := ""
-	for i, scene := range video.Scenes {
-		scenesSummary += fmt.Sprintf("\n- Scene %d: '%s'. Video Prompt: '%s'", i+1, scene.Description, scene.Prompt)
+	// budgetTolerance is how far over its word budget a scene may run before
+	// the script is rejected, to allow for the model miscounting slightly.
+	budgetTolerance = 1.2
+
+	// scriptAttempts is how many times GenerateScript asks the LLM for a script
+	// that fits the budgets before giving up.
+	scriptAttempts = 2
+)
+
+// ScriptResponse is the structured output for the script LLM call
+type ScriptResponse struct {
+	Hook         string       `json:"hook" jsonschema_description:"The opening line, spoken at the very start of the first scene. It must grab attention in the first two seconds."`
+	Scenes       []ScriptLine `json:"scenes" jsonschema_description:"One narration line per scene, in scene order."`
+	CallToAction string       `json:"call_to_action" jsonschema_description:"The closing line, spoken at the end of the last scene, asking the viewer to follow, like or comment."`
+}
+
+// ScriptLine is the narration for a single scene
+type ScriptLine struct {
+	SceneNumber int    `json:"scene_number" jsonschema_description:"The number of the scene this line is spoken over."`
+	Narration   string `json:"narration" jsonschema_description:"The voiceover spoken over the scene, excluding the hook and call to action."`
+}
+
+var scriptResponseSchema = GenerateSchema[ScriptResponse]()
+
+// Script is a voiceover split into the lines spoken over each scene.
+type Script struct {
+	Hook         string
+	CallToAction string
+	Scenes       []SceneNarration // Ordered by scene number
+
+	PromptVersion string // Version of the script prompt template used
+}
+
+// SceneNarration is everything spoken over one scene. The first scene's text
+// starts with the hook and the last scene's text ends with the call to action.
+type SceneNarration struct {
+	SceneNumber int
+	Narration   string
+	WordCount   int
+}
+
+// Text assembles the full voiceover.
+func (s *Script) Text() string {
+	lines := make([]string, 0, len(s.Scenes))
+	for _, scene := range s.Scenes {
+		lines = append(lines, scene.Narration)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// WordBudget returns the maximum number of words that fit in a scene.
+func WordBudget(duration float32) int {
+	budget := int(math.Floor(float64(duration) * WordsPerSecond))
+	if budget < 3 {
+		budget = 3
+	}
+	return budget
+}
+
+// GenerateScript writes a voiceover for the video's scenes, with a hook on the
+// first scene, a call to action on the last and each scene's narration sized to
+// its duration.
+func GenerateScript(ctx context.Context, llm LLM, video models.Video, series models.Series) (*Script, error) {
+	if len(video.Scenes) == 0 {
+		return nil, fmt.Errorf("video %d has no scenes to narrate", video.ID)
+	}
+
+	scenes := make([]models.VideoScene, len(video.Scenes))
+	copy(scenes, video.Scenes)
+	sort.Slice(scenes, func(i, j int) bool { return scenes[i].SceneNumber < scenes[j].SceneNumber })
+
+	var sceneList strings.Builder
+	for _, scene := range scenes {
+		fmt.Fprintf(&sceneList, "- Scene %d (%.1f seconds, at most %d words): %s\n",
+			scene.SceneNumber, scene.Duration, WordBudget(scene.Duration), scene.Description)
+	}
+
+	data := newPromptData(series, video.Title)
+	data.SceneList = sceneList.String()
+	data.FirstScene = scenes[0].SceneNumber
+	data.LastScene = scenes[len(scenes)-1].SceneNumber
+	prompt, promptVersion, err := renderPrompt(PromptScript, video.ID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= scriptAttempts; attempt++ {
+		attemptPrompt := prompt
+		if lastErr != nil {
+			attemptPrompt += fmt.Sprintf("\n\nYour previous script was rejected: %v. Fix this and keep every scene within its word limit.", lastErr)
+		}
+
+		resp, err := complete[ScriptResponse](ctx, llm, CompletionRequest{
+			Name:        "video_script",
+			Description: "A voiceover script split by scene",
+			Prompt:      attemptPrompt,
+			Schema:      scriptResponseSchema,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate script: %w", err)
+		}
+
+		script, err := buildScript(resp, scenes)
+		if err == nil {
+			script.PromptVersion = promptVersion
+			return script, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("failed to generate script within word budgets: %w", lastErr)
+}
+
+// buildScript matches the LLM's lines to scenes and checks them against each
+// scene's word budget. scenes must be sorted by scene number.
+func buildScript(resp *ScriptResponse, scenes []models.VideoScene) (*Script, error) {
+	hook := strings.TrimSpace(resp.Hook)
+	cta := strings.TrimSpace(resp.CallToAction)
+	if hook == "" {
+		return nil, fmt.Errorf("the hook is empty")
+	}
+	if cta == "" {
+		return nil, fmt.Errorf("the call to action is empty")
 	}
 
-	// Return an improved placeholder script to reflect the change
-	script := fmt.Sprintf(`NARRATOR: Welcome to the series '%s', today we talk about: %s. 
-The script's voiceover will be written to match the following visual scenes and prompts:
-%s
-`,
-		series.Title, video.Title, scenesSummary)
+	lines := make(map[int]string, len(resp.Scenes))
+	for _, line := range resp.Scenes {
+		if _, dup := lines[line.SceneNumber]; dup {
+			return nil, fmt.Errorf("scene %d has more than one line", line.SceneNumber)
+		}
+		lines[line.SceneNumber] = strings.TrimSpace(line.Narration)
+	}
+
+	script := &Script{Hook: hook, CallToAction: cta}
+	var problems []string
+	for i, scene := range scenes {
+		line, ok := lines[scene.SceneNumber]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("scene %d has no line", scene.SceneNumber))
+			continue
+		}
+		delete(lines, scene.SceneNumber)
+
+		parts := []string{line}
+		if i == 0 {
+			parts = append([]string{hook}, parts...)
+		}
+		if i == len(scenes)-1 {
+			parts = append(parts, cta)
+		}
+		narration := strings.TrimSpace(strings.Join(parts, " "))
+		words := len(strings.Fields(narration))
 
+		budget := WordBudget(scene.Duration)
+		if float64(words) > float64(budget)*budgetTolerance {
+			problems = append(problems, fmt.Sprintf("scene %d has %d words but at most %d fit", scene.SceneNumber, words, budget))
+		}
+
+		script.Scenes = append(script.Scenes, SceneNarration{
+			SceneNumber: scene.SceneNumber,
+			Narration:   narration,
+			WordCount:   words,
+		})
+	}
+	for number := range lines {
+		problems = append(problems, fmt.Sprintf("scene %d does not exist", number))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
 	return script, nil
 }
@@ -0,0 +1,213 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+// scriptedLLM answers each completion with the next of its responses and
+// records the prompts it was sent.
+type scriptedLLM struct {
+	responses []interface{}
+	prompts   []string
+}
+
+func (s *scriptedLLM) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	s.prompts = append(s.prompts, req.Prompt)
+	content, err := json.Marshal(s.responses[0])
+	if err != nil {
+		return nil, err
+	}
+	s.responses = s.responses[1:]
+	return &Completion{Content: string(content)}, nil
+}
+
+// testScenes are two scenes of 4 and 2 seconds, fitting 10 and 5 words.
+func testScenes() []models.VideoScene {
+	return []models.VideoScene{
+		{SceneNumber: 1, Duration: 4, Description: "A sunrise"},
+		{SceneNumber: 2, Duration: 2, Description: "A sunset"},
+	}
+}
+
+func TestWordBudget(t *testing.T) {
+	tests := []struct {
+		duration float32
+		want     int
+	}{
+		{4, 10},
+		{5.9, 14},
+		{0.5, 3},
+		{0, 3},
+	}
+	for _, tt := range tests {
+		if got := WordBudget(tt.duration); got != tt.want {
+			t.Errorf("WordBudget(%v) = %d, want %d", tt.duration, got, tt.want)
+		}
+	}
+}
+
+func TestBuildScriptPlacesHookAndCallToAction(t *testing.T) {
+	resp := &ScriptResponse{
+		Hook: " Wait for it. ",
+		// Lines may come back out of order
+		Scenes: []ScriptLine{
+			{SceneNumber: 2, Narration: "Then it sets."},
+			{SceneNumber: 1, Narration: " The sun rises. "},
+		},
+		CallToAction: "Follow!",
+	}
+
+	script, err := buildScript(resp, testScenes())
+	if err != nil {
+		t.Fatalf("buildScript: %v", err)
+	}
+	want := []SceneNarration{
+		{SceneNumber: 1, Narration: "Wait for it. The sun rises.", WordCount: 6},
+		{SceneNumber: 2, Narration: "Then it sets. Follow!", WordCount: 4},
+	}
+	if len(script.Scenes) != len(want) {
+		t.Fatalf("got %d scenes, want %d", len(script.Scenes), len(want))
+	}
+	for i := range want {
+		if script.Scenes[i] != want[i] {
+			t.Errorf("scene %d = %+v, want %+v", i, script.Scenes[i], want[i])
+		}
+	}
+	if script.Hook != "Wait for it." || script.CallToAction != "Follow!" {
+		t.Errorf("hook %q and call to action %q not trimmed", script.Hook, script.CallToAction)
+	}
+	if got := script.Text(); got != "Wait for it. The sun rises.\nThen it sets. Follow!" {
+		t.Errorf("Text() = %q", got)
+	}
+}
+
+func TestBuildScriptSingleSceneCarriesHookAndCallToAction(t *testing.T) {
+	resp := &ScriptResponse{
+		Hook:         "Look.",
+		Scenes:       []ScriptLine{{SceneNumber: 3, Narration: "A sunrise."}},
+		CallToAction: "Follow!",
+	}
+	scenes := []models.VideoScene{{SceneNumber: 3, Duration: 4}}
+
+	script, err := buildScript(resp, scenes)
+	if err != nil {
+		t.Fatalf("buildScript: %v", err)
+	}
+	if len(script.Scenes) != 1 || script.Scenes[0].Narration != "Look. A sunrise. Follow!" {
+		t.Errorf("scenes = %+v, want the hook, line and call to action together", script.Scenes)
+	}
+}
+
+func TestBuildScriptAllowsSlightOverrun(t *testing.T) {
+	// Scene 2 fits 5 words; with the tolerance 6 are accepted
+	resp := &ScriptResponse{
+		Hook:         "Look.",
+		Scenes:       []ScriptLine{{SceneNumber: 1, Narration: "A sunrise."}, {SceneNumber: 2, Narration: "one two three four five"}},
+		CallToAction: "Follow!",
+	}
+	if _, err := buildScript(resp, testScenes()); err != nil {
+		t.Errorf("buildScript rejected a line within the tolerance: %v", err)
+	}
+}
+
+func TestBuildScriptRejectsInvalidScripts(t *testing.T) {
+	line1 := ScriptLine{SceneNumber: 1, Narration: "The sun rises."}
+	line2 := ScriptLine{SceneNumber: 2, Narration: "It sets."}
+	tests := []struct {
+		name string
+		resp ScriptResponse
+		want string
+	}{
+		{
+			name: "empty hook",
+			resp: ScriptResponse{Hook: "  ", Scenes: []ScriptLine{line1, line2}, CallToAction: "Follow!"},
+			want: "the hook is empty",
+		},
+		{
+			name: "empty call to action",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{line1, line2}},
+			want: "the call to action is empty",
+		},
+		{
+			name: "duplicate line",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{line1, line1, line2}, CallToAction: "Follow!"},
+			want: "scene 1 has more than one line",
+		},
+		{
+			name: "missing line",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{line1}, CallToAction: "Follow!"},
+			want: "scene 2 has no line",
+		},
+		{
+			name: "unknown scene",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{line1, line2, {SceneNumber: 9, Narration: "Extra."}}, CallToAction: "Follow!"},
+			want: "scene 9 does not exist",
+		},
+		{
+			name: "over budget with call to action",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{line1, {SceneNumber: 2, Narration: "one two three four five"}}, CallToAction: "Follow us now!"},
+			want: "scene 2 has 8 words but at most 5 fit",
+		},
+		{
+			name: "every problem reported",
+			resp: ScriptResponse{Hook: "Look.", Scenes: []ScriptLine{{SceneNumber: 9, Narration: "Extra."}}, CallToAction: "Follow!"},
+			want: "scene 1 has no line; scene 2 has no line; scene 9 does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := buildScript(&tt.resp, testScenes())
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("buildScript error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateScriptRetriesRejectedScripts(t *testing.T) {
+	tooLong := ScriptResponse{
+		Hook:         "Look.",
+		Scenes:       []ScriptLine{{SceneNumber: 1, Narration: "The sun rises."}, {SceneNumber: 2, Narration: "It sets over the quiet sea tonight."}},
+		CallToAction: "Follow!",
+	}
+	fits := ScriptResponse{
+		Hook:         "Look.",
+		Scenes:       []ScriptLine{{SceneNumber: 1, Narration: "The sun rises."}, {SceneNumber: 2, Narration: "It sets."}},
+		CallToAction: "Follow!",
+	}
+	llm := &scriptedLLM{responses: []interface{}{tooLong, fits}}
+	video := models.Video{Title: "Sun", Scenes: []models.VideoScene{testScenes()[1], testScenes()[0]}}
+
+	script, err := GenerateScript(context.Background(), llm, video, models.Series{Title: "Nature"})
+	if err != nil {
+		t.Fatalf("GenerateScript: %v", err)
+	}
+	if len(llm.prompts) != 2 {
+		t.Fatalf("sent %d prompts, want 2", len(llm.prompts))
+	}
+	if !strings.Contains(llm.prompts[1], "Your previous script was rejected: scene 2 has 8 words but at most 5 fit") {
+		t.Errorf("retry prompt does not explain the rejection:\n%s", llm.prompts[1])
+	}
+	if script.Scenes[1].Narration != "It sets. Follow!" || script.PromptVersion == "" {
+		t.Errorf("script = %+v, want the second response with its prompt version", script)
+	}
+}
+
+func TestGenerateScriptGivesUpAfterAttempts(t *testing.T) {
+	empty := ScriptResponse{Scenes: []ScriptLine{{SceneNumber: 1, Narration: "The sun rises."}}, CallToAction: "Follow!"}
+	llm := &scriptedLLM{responses: []interface{}{empty, empty}}
+	video := models.Video{Scenes: testScenes()[:1]}
+
+	_, err := GenerateScript(context.Background(), llm, video, models.Series{})
+	if err == nil || !strings.Contains(err.Error(), "the hook is empty") {
+		t.Errorf("GenerateScript error = %v, want the last rejection", err)
+	}
+	if len(llm.prompts) != scriptAttempts {
+		t.Errorf("sent %d prompts, want %d", len(llm.prompts), scriptAttempts)
+	}
+}
@@ -0,0 +1,67 @@
+package processing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+// --- Series Style ---
+
+// Defaults used for style fields a series leaves empty. A series without a
+// style profile gets the same look as before profiles existed.
+const (
+	defaultVisualStyle  = "cinematic, 4k, hyperrealistic"
+	defaultColorGrading = "vibrant cyberpunk"
+	defaultToneOfVoice  = "energetic and conversational"
+)
+
+// resolveStyle fills empty fields of a series' style profile with defaults.
+func resolveStyle(series models.Series) models.StyleProfile {
+	style := series.Style
+	if style.VisualStyle == "" {
+		style.VisualStyle = defaultVisualStyle
+	}
+	if style.ColorGrading == "" {
+		style.ColorGrading = defaultColorGrading
+	}
+	if style.ToneOfVoice == "" {
+		style.ToneOfVoice = defaultToneOfVoice
+	}
+	return style
+}
+
+// visualStylePrompt describes the look shared by every scene of a series.
+func visualStylePrompt(series models.Series) string {
+	style := resolveStyle(series)
+	return fmt.Sprintf("A %s themed video with a %s color grading, %s", series.Title, style.ColorGrading, style.VisualStyle)
+}
+
+// audienceGuidelines lists the series' tone, audience and banned topics as
+// prompt bullet points. Fields that are unset are left out.
+func audienceGuidelines(series models.Series) string {
+	style := resolveStyle(series)
+	lines := []string{fmt.Sprintf("- Tone of voice: %s", style.ToneOfVoice)}
+	if style.TargetAudience != "" {
+		lines = append(lines, fmt.Sprintf("- Target audience: %s", style.TargetAudience))
+	}
+	if style.NarratorPersona != "" {
+		lines = append(lines, fmt.Sprintf("- Narrator persona: %s", style.NarratorPersona))
+	}
+	if topics := bannedTopics(style); topics != "" {
+		lines = append(lines, fmt.Sprintf("- Never mention or depict these topics: %s", topics))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// bannedTopics joins the non-empty banned topics of a style.
+func bannedTopics(style models.StyleProfile) string {
+	var topics []string
+	for _, topic := range style.BannedTopics {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return strings.Join(topics, ", ")
+}
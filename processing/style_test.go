@@ -0,0 +1,31 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+func TestVisualStylePrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		series models.Series
+		want   string
+	}{
+		{
+			name:   "no style profile keeps the original look",
+			series: models.Series{Title: "Space"},
+			want:   "A Space themed video with a vibrant cyberpunk color grading, cinematic, 4k, hyperrealistic",
+		},
+		{
+			name:   "profile overrides the defaults",
+			series: models.Series{Title: "Space", Style: models.StyleProfile{VisualStyle: "hand-drawn anime", ColorGrading: "warm film"}},
+			want:   "A Space themed video with a warm film color grading, hand-drawn anime",
+		},
+	}
+	for _, tt := range tests {
+		if got := visualStylePrompt(tt.series); got != tt.want {
+			t.Errorf("%s: visualStylePrompt() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,19 +2,14 @@ package processing
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"strings"
 
 	"github.com/drewmudry/instashorts-api/models"
 	"github.com/invopop/jsonschema" //
-	"github.com/openai/openai-go/v3"
-	"github.com/openai/openai-go/v3/option"
 )
 
-// TitleResponse represents the JSON response from OpenAI
+// TitleResponse represents the JSON response from the LLM
 type TitleResponse struct {
 	Title string `json:"title" jsonschema_description:"A unique, engaging title for the video"` //
 }
@@ -39,85 +34,32 @@ func init() {
 	titleResponseSchema = GenerateSchema[TitleResponse]()
 }
 
-// GenerateTitle calls OpenAI to generate a unique title for a video
-func GenerateTitle(ctx context.Context, series models.Series, existingTitles []string) (string, error) {
-	// ... (rest of the function is the same)
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY environment variable not set")
+// GenerateTitle asks the LLM for a unique title for a video. It also returns
+// the version of the prompt template used.
+func GenerateTitle(ctx context.Context, llm LLM, video models.Video, series models.Series, existingTitles []string) (title, promptVersion string, err error) {
+	data := newPromptData(series, "")
+	data.ExistingTitles = formatExistingTitles(existingTitles)
+	prompt, promptVersion, err := renderPrompt(PromptTitle, video.ID, data)
+	if err != nil {
+		return "", "", err
 	}
 
-	client := openai.NewClient(
-		option.WithAPIKey(apiKey),
-	)
-
-	// Build the prompt
-	prompt := fmt.Sprintf(`You are creating a title for a new video in a series.
-
-Series Title: %s
-Series Description: %s
-
-The following titles have already been used in this series:
-%s
-
-Generate a unique, engaging title for the next video in this series. The title should:
-- Be relevant to the series theme
-- Be different from all existing titles
-- Be catchy and engaging
-- Be under 100 characters
-- Be niched down to a specific topic or theme
-
-Respond in JSON format with this structure:
-{
-  "title": "your generated title here"
-}`, series.Title, series.Description, formatExistingTitles(existingTitles))
-
-	schemaParam := openai.ResponseFormatJSONSchemaJSONSchemaParam{
+	titleResp, err := complete[TitleResponse](ctx, llm, CompletionRequest{
 		Name:        "video_title",
-		Description: openai.String("A unique title for a video in a series"),
+		Description: "A unique title for a video in a series",
+		Prompt:      prompt,
 		Schema:      titleResponseSchema,
-		Strict:      openai.Bool(true),
-	}
-
-	chatCompletion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Messages: []openai.ChatCompletionMessageParamUnion{
-			openai.UserMessage(prompt),
-		},
-		Model: openai.ChatModelGPT4oMini,
-		ResponseFormat: openai.ChatCompletionNewParamsResponseFormatUnion{
-			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
-				JSONSchema: schemaParam,
-			},
-		},
 	})
-
 	if err != nil {
-		return "", fmt.Errorf("OpenAI API error: %w", err)
-	}
-
-	if len(chatCompletion.Choices) == 0 {
-		return "", fmt.Errorf("no response from OpenAI")
-	}
-
-	rawResponse := chatCompletion.Choices[0].Message.Content
-	log.Printf("OpenAI Response: %s", rawResponse)
-
-	if rawResponse == "" {
-		return "", fmt.Errorf("OpenAI returned empty response. Finish reason: %s", chatCompletion.Choices[0].FinishReason)
-	}
-
-	// Parse the JSON response
-	var titleResp TitleResponse
-	if err := json.Unmarshal([]byte(rawResponse), &titleResp); err != nil {
-		return "", fmt.Errorf("failed to parse OpenAI JSON response: %w", err)
+		return "", "", err
 	}
 
-	title := strings.TrimSpace(titleResp.Title)
+	title = strings.TrimSpace(titleResp.Title)
 	if title == "" {
-		return "", fmt.Errorf("OpenAI returned empty title")
+		return "", "", fmt.Errorf("LLM returned empty title")
 	}
 
-	return title, nil
+	return title, promptVersion, nil
 }
 
 // formatExistingTitles formats the list of existing titles for the prompt
@@ -0,0 +1,247 @@
+package referrals
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Referral Funnel ---
+// A visit to a referral link is recorded as a click, whose token is kept in a
+// cookie (and passed through the OAuth state) until the visitor signs up. The
+// click is then linked to the new user, and marked converted on their first
+// paid invoice.
+
+const (
+	// ClickCookie holds the token of the visitor's latest referral click.
+	ClickCookie = "ref_click"
+
+	// ClickAttributionWindow is how long after a click a signup is credited
+	// to it.
+	ClickAttributionWindow = 30 * 24 * time.Hour
+
+	maxUserAgentLength  = 512
+	maxLandingURLLength = 2048
+)
+
+type TrackClickRequest struct {
+	ReferralCode string `json:"referral_code" binding:"required"`
+	LandingURL   string `json:"landing_url"`
+}
+
+// TrackClick records a visit to a referral link. It's public: the frontend
+// calls it when a page is opened with ?ref=. The click token is set as a
+// cookie and returned so it can be passed to /auth/google as ?click=.
+func (h *Handler) TrackClick(c *gin.Context) {
+	var req TrackClickRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var referrer models.User
+	code := strings.ToLower(strings.TrimSpace(req.ReferralCode))
+	if err := h.DB.Where("referral_code = ? AND referral_code_active", code).First(&referrer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Referral code not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	token, err := newClickToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create click token"})
+		return
+	}
+
+	click := models.ReferralClick{
+		ReferrerUserID: referrer.ID,
+		Token:          token,
+		IPHash:         hashIP(c.ClientIP()),
+		UserAgent:      truncate(c.Request.UserAgent(), maxUserAgentLength),
+		LandingURL:     truncate(req.LandingURL, maxLandingURLLength),
+	}
+	if err := h.DB.Create(&click).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record click"})
+		return
+	}
+
+	isProduction := os.Getenv("ENV") == "production"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(ClickCookie, token, int(ClickAttributionWindow.Seconds()), "/", "", isProduction, true)
+
+	c.JSON(http.StatusCreated, gin.H{"click_token": token})
+}
+
+// FindClick returns the click with the given token if it is still within the
+// attribution window, or nil.
+func FindClick(db *gorm.DB, token string) (*models.ReferralClick, error) {
+	if token == "" {
+		return nil, nil
+	}
+	var click models.ReferralClick
+	err := db.Where("token = ? AND created_at >= ?", token, time.Now().Add(-ClickAttributionWindow)).First(&click).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &click, nil
+}
+
+// RecordSignup links a click to the user who signed up from it.
+func RecordSignup(db *gorm.DB, clickID, userID uint) error {
+	return db.Model(&models.ReferralClick{}).
+		Where("id = ? AND signed_up_user_id IS NULL", clickID).
+		Updates(map[string]interface{}{
+			"signed_up_user_id": userID,
+			"signed_up_at":      time.Now().UTC(),
+		}).Error
+}
+
+// RecordConversion records a referred user's payment made at paidAt. Their
+// earliest payment converts the click they signed up from and starts their
+// referrer's commission window; that start is returned. Invoice events can
+// arrive out of order, so a later event with an earlier payment moves it back.
+func RecordConversion(db *gorm.DB, userID uint, paidAt time.Time) (time.Time, error) {
+	err := db.Model(&models.User{}).
+		Where("id = ? AND (referral_converted_at IS NULL OR referral_converted_at > ?)", userID, paidAt).
+		Update("referral_converted_at", paidAt).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = db.Model(&models.ReferralClick{}).
+		Where("signed_up_user_id = ? AND (converted_at IS NULL OR converted_at > ?)", userID, paidAt).
+		Update("converted_at", paidAt).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var user models.User
+	if err := db.Select("referral_converted_at").First(&user, userID).Error; err != nil {
+		return time.Time{}, err
+	}
+	return user.ReferralConvertedAt.UTC(), nil
+}
+
+// newClickToken returns a random, URL-safe click token.
+func newClickToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashIP keys an IP with REFERRAL_IP_SALT so clicks from the same address can
+// be told apart without storing it. Without a salt nothing is stored, since an
+// unsalted hash of an IP is easily reversed.
+func hashIP(ip string) string {
+	salt := os.Getenv("REFERRAL_IP_SALT")
+	if salt == "" || ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// truncate cuts s to at most n bytes.
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// --- Funnel Stats ---
+
+// FunnelCounts are clicks and what came of them.
+type FunnelCounts struct {
+	Clicks          int64 `json:"clicks"`
+	Signups         int64 `json:"signups"`
+	PaidConversions int64 `json:"paid_conversions"`
+}
+
+// FunnelRates are the conversion rates of a set of clicks, as fractions.
+type FunnelRates struct {
+	SignupRate       float64 `json:"signup_rate"`         // Signups / clicks
+	PaidRate         float64 `json:"paid_rate"`           // Paid conversions / clicks
+	SignupToPaidRate float64 `json:"signup_to_paid_rate"` // Paid conversions / signups
+}
+
+// DailyFunnel is the funnel of the clicks made on one UTC day, so its rates
+// show how well that day's traffic converted.
+type DailyFunnel struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	FunnelCounts
+	FunnelRates
+}
+
+// Funnel is a referrer's click funnel over a period.
+type Funnel struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	FunnelCounts
+	FunnelRates
+	Daily []DailyFunnel `json:"daily"`
+}
+
+const funnelSelect = "COUNT(*) AS clicks, " +
+	"COUNT(signed_up_user_id) AS signups, " +
+	"COUNT(converted_at) AS paid_conversions"
+
+// LoadFunnel aggregates the clicks on a referrer's links made since from.
+func LoadFunnel(db *gorm.DB, referrerID uint, from, to time.Time) (*Funnel, error) {
+	funnel := &Funnel{From: from, To: to, Daily: []DailyFunnel{}}
+	scope := func() *gorm.DB {
+		return db.Model(&models.ReferralClick{}).
+			Where("referrer_user_id = ? AND created_at >= ?", referrerID, from)
+	}
+
+	if err := scope().Select(funnelSelect).Scan(&funnel.FunnelCounts).Error; err != nil {
+		return nil, err
+	}
+	funnel.FunnelRates = funnel.FunnelCounts.rates()
+
+	err := scope().
+		Select("TO_CHAR(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS date, " + funnelSelect).
+		Group("date").
+		Order("date").
+		Scan(&funnel.Daily).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range funnel.Daily {
+		funnel.Daily[i].FunnelRates = funnel.Daily[i].FunnelCounts.rates()
+	}
+	return funnel, nil
+}
+
+func (c FunnelCounts) rates() FunnelRates {
+	return FunnelRates{
+		SignupRate:       ratio(c.Signups, c.Clicks),
+		PaidRate:         ratio(c.PaidConversions, c.Clicks),
+		SignupToPaidRate: ratio(c.PaidConversions, c.Signups),
+	}
+}
+
+func ratio(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
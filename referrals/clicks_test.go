@@ -0,0 +1,69 @@
+package referrals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB returns an in-memory database with the referral tables.
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.ReferralClick{}, &models.ReferralCommission{},
+		&models.ReferralPayout{}, &models.CommissionRule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestRecordConversionKeepsEarliestPayment(t *testing.T) {
+	db := newTestDB(t)
+	user := models.User{GoogleID: "g1", Email: "a@example.com"}
+	db.Create(&user)
+	click := models.ReferralClick{ReferrerUserID: 1, Token: "tok", SignedUpUserID: &user.ID}
+	db.Create(&click)
+
+	first := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	// The second invoice's event arrives first, then the first's, then a third
+	for _, paidAt := range []time.Time{first.AddDate(0, 1, 0), first, first.AddDate(0, 2, 0)} {
+		got, err := RecordConversion(db, user.ID, paidAt)
+		if err != nil {
+			t.Fatalf("RecordConversion(%v): %v", paidAt, err)
+		}
+		if paidAt.Equal(first.AddDate(0, 1, 0)) {
+			continue
+		}
+		if !got.Equal(first) {
+			t.Errorf("RecordConversion(%v) = %v, want the first payment %v", paidAt, got, first)
+		}
+	}
+
+	db.First(&click, click.ID)
+	if click.ConvertedAt == nil || !click.ConvertedAt.Equal(first) {
+		t.Errorf("click converted at %v, want %v", click.ConvertedAt, first)
+	}
+}
+
+func TestRecordConversionWithoutClick(t *testing.T) {
+	db := newTestDB(t)
+	// Signed up with a referral code only
+	user := models.User{GoogleID: "g1", Email: "a@example.com"}
+	db.Create(&user)
+
+	paidAt := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	got, err := RecordConversion(db, user.ID, paidAt)
+	if err != nil {
+		t.Fatalf("RecordConversion: %v", err)
+	}
+	if !got.Equal(paidAt) {
+		t.Errorf("RecordConversion = %v, want %v", got, paidAt)
+	}
+}
@@ -0,0 +1,105 @@
+package referrals
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/account"
+	"gorm.io/gorm"
+)
+
+// --- Connect Account State ---
+// A referrer's Connect account state is cached on the user from
+// account.updated webhooks. A referral code only works while payouts are
+// enabled: it is deactivated when they're disabled and reactivated when
+// they're enabled again.
+
+// SyncConnectAccount stores the state of a Connect account on the user who
+// owns it. asOf is when the state was observed; older states than the one
+// stored are ignored, so out-of-order webhooks can't roll it back. It returns
+// the updated user, or nil if no user owns the account or the state is stale.
+func SyncConnectAccount(db *gorm.DB, acct *stripe.Account, asOf time.Time) (*models.User, error) {
+	var user models.User
+	if err := db.Where("stripe_connect_account_id = ?", acct.ID).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to load user for account %s: %w", acct.ID, err)
+	}
+
+	codeActive := acct.PayoutsEnabled && user.ReferralCode != nil
+	// A struct update, so the requirements go through their JSON serializer;
+	// Select writes the false values too.
+	updates := models.User{
+		ConnectChargesEnabled:   acct.ChargesEnabled,
+		ConnectPayoutsEnabled:   acct.PayoutsEnabled,
+		ConnectDetailsSubmitted: acct.DetailsSubmitted,
+		ConnectRequirements:     connectRequirements(acct.Requirements),
+		ConnectSyncedAt:         &asOf,
+		ReferralCodeActive:      codeActive,
+	}
+	res := db.Model(&models.User{}).
+		Where("id = ? AND (connect_synced_at IS NULL OR connect_synced_at <= ?)", user.ID, asOf).
+		Select("ConnectChargesEnabled", "ConnectPayoutsEnabled", "ConnectDetailsSubmitted",
+			"ConnectRequirements", "ConnectSyncedAt", "ReferralCodeActive").
+		Updates(&updates)
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to sync account %s: %w", acct.ID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	if user.ReferralCodeActive && !codeActive {
+		log.Printf("Deactivated referral code of user %d: payouts disabled on account %s", user.ID, acct.ID)
+	} else if !user.ReferralCodeActive && codeActive {
+		log.Printf("Reactivated referral code of user %d: payouts enabled on account %s", user.ID, acct.ID)
+	}
+
+	if err := db.First(&user, user.ID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RefreshConnectAccount fetches a user's Connect account from Stripe and
+// syncs it. It's the fallback for accounts whose state hasn't been synced
+// by a webhook yet.
+func RefreshConnectAccount(db *gorm.DB, user *models.User) error {
+	if user.StripeConnectAccountID == nil || *user.StripeConnectAccountID == "" {
+		return nil
+	}
+	acct, err := account.GetByID(*user.StripeConnectAccountID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve account %s: %w", *user.StripeConnectAccountID, err)
+	}
+	synced, err := SyncConnectAccount(db, acct, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if synced != nil {
+		*user = *synced
+	}
+	return nil
+}
+
+// connectRequirements converts Stripe's requirements to the stored form.
+func connectRequirements(r *stripe.AccountRequirements) *models.ConnectRequirements {
+	if r == nil {
+		return nil
+	}
+	req := &models.ConnectRequirements{
+		CurrentlyDue:   r.CurrentlyDue,
+		PastDue:        r.PastDue,
+		EventuallyDue:  r.EventuallyDue,
+		DisabledReason: string(r.DisabledReason),
+	}
+	if r.CurrentDeadline > 0 {
+		deadline := time.Unix(r.CurrentDeadline, 0).UTC()
+		req.CurrentDeadline = &deadline
+	}
+	return req
+}
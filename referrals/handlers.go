@@ -3,14 +3,20 @@ package referrals
 import (
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/drewmudry/instashorts-api/models"
 	"github.com/gin-gonic/gin"
-	"github.com/stripe/stripe-go/v76/account"
 	"gorm.io/gorm"
 )
 
+const (
+	defaultFunnelDays = 30
+	maxFunnelDays     = 365
+)
+
 type Handler struct {
 	DB *gorm.DB
 }
@@ -71,14 +77,16 @@ func (h *Handler) SetReferralCode(c *gin.Context) {
 		return
 	}
 
-	// Verify the Stripe account is fully onboarded (payouts enabled)
-	acct, err := account.GetByID(*user.StripeConnectAccountID, nil)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify Stripe account status"})
-		return
+	// Verify the Stripe account is fully onboarded (payouts enabled), using
+	// the state synced from webhooks when we have it
+	if user.ConnectSyncedAt == nil {
+		if err := RefreshConnectAccount(h.DB, &user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify Stripe account status"})
+			return
+		}
 	}
 
-	if !acct.PayoutsEnabled {
+	if !user.ConnectPayoutsEnabled {
 		c.JSON(http.StatusForbidden, gin.H{
 			"error":   "Stripe Connect onboarding incomplete",
 			"message": "Please complete your Stripe Connect onboarding to enable payouts before setting up a referral code.",
@@ -87,6 +95,7 @@ func (h *Handler) SetReferralCode(c *gin.Context) {
 	}
 
 	user.ReferralCode = &req.ReferralCode
+	user.ReferralCodeActive = true
 	if err := h.DB.Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update referral code"})
 		return
@@ -98,10 +107,21 @@ func (h *Handler) SetReferralCode(c *gin.Context) {
 	})
 }
 
-// GetReferralStats returns referral statistics for the authenticated user
+// GetReferralStats returns referral statistics for the authenticated user,
+// including the click funnel of the last ?days= days (default 30)
 func (h *Handler) GetReferralStats(c *gin.Context) {
 	userID := c.GetUint("user_id")
 
+	days := defaultFunnelDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFunnelDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+			return
+		}
+		days = n
+	}
+
 	var user models.User
 	if err := h.DB.First(&user, userID).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
@@ -112,10 +132,51 @@ func (h *Handler) GetReferralStats(c *gin.Context) {
 	var referredCount int64
 	h.DB.Model(&models.User{}).Where("referred_by_user_id = ?", userID).Count(&referredCount)
 
+	tier, err := ResolveRule(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load commission rule"})
+		return
+	}
+
+	// Commissions earned but not yet paid out
+	var pendingCents int64
+	if err := h.DB.Model(&models.ReferralCommission{}).
+		Where("referrer_user_id = ? AND status = ?", userID, models.CommissionPending).
+		Select("COALESCE(SUM(amount_cents - reversed_cents), 0)").Scan(&pendingCents).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load pending commissions"})
+		return
+	}
+
+	commission := gin.H{
+		"rate_bps":            tier.Rule.RateBps,
+		"window_months":       tier.Rule.WindowMonths,
+		"min_payout_cents":    tier.Rule.MinPayoutCents,
+		"active_referrals":    tier.ActiveReferrals,
+		"is_override":         tier.Override,
+		"next_tier_rate_bps":  nil,
+		"next_tier_referrals": nil,
+	}
+	if tier.Next != nil {
+		commission["next_tier_rate_bps"] = tier.Next.RateBps
+		commission["next_tier_referrals"] = tier.Next.MinActiveReferrals
+	}
+
+	now := time.Now().UTC()
+	from := now.Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	funnel, err := LoadFunnel(h.DB, userID, from, now)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load referral funnel"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"referral_code":           user.ReferralCode,
+		"referral_code_active":    user.ReferralCodeActive,
 		"referred_users_count":    referredCount,
 		"referral_earnings_cents": user.ReferralEarningsCents,
+		"pending_earnings_cents":  pendingCents,
 		"can_earn_referrals":      user.CanEarnReferrals(),
+		"commission":              commission,
+		"funnel":                  funnel,
 	})
 }
@@ -0,0 +1,363 @@
+package referrals
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/transfer"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// --- Payouts ---
+// Commissions accrue as pending. Each payout run groups a referrer's pending
+// commissions into one payout per currency, once they reach the referrer's
+// payout threshold, and transfers it. Failed transfers are retried with
+// backoff while the failure looks temporary, e.g. the referrer's Connect
+// account isn't ready to receive transfers yet.
+
+const (
+	// MaxPayoutAttempts is how many times a payout's transfer is tried before
+	// it is marked failed.
+	MaxPayoutAttempts = 8
+
+	payoutRetryBase = time.Hour
+	payoutRetryMax  = 24 * time.Hour
+)
+
+// errNoConnectAccount fails payouts to referrers who removed their account.
+var errNoConnectAccount = errors.New("referrer has no Stripe Connect account")
+
+// CommissionStatus is the status of a commission with reversedCents reversed.
+func CommissionStatus(c models.ReferralCommission, reversedCents int64) string {
+	switch {
+	case reversedCents >= c.AmountCents:
+		return models.CommissionReversed
+	case c.TransferID == "":
+		return models.CommissionPending
+	case reversedCents > 0:
+		return models.CommissionPartiallyReversed
+	default:
+		return models.CommissionPaid
+	}
+}
+
+// CreatePayouts assigns pending commissions to new payouts for the period
+// starting at period. Referrers whose pending total is under their payout
+// threshold are left to accrue. It returns the number of payouts created.
+func CreatePayouts(db *gorm.DB, period time.Time) (int, error) {
+	type group struct {
+		ReferrerUserID uint
+		Currency       string
+	}
+	var groups []group
+	if err := db.Model(&models.ReferralCommission{}).
+		Distinct("referrer_user_id", "currency").
+		Where("status = ? AND payout_id IS NULL", models.CommissionPending).
+		Find(&groups).Error; err != nil {
+		return 0, fmt.Errorf("failed to load pending commissions: %w", err)
+	}
+
+	created := 0
+	for _, g := range groups {
+		ok, err := createPayout(db, g.ReferrerUserID, g.Currency, period)
+		if err != nil {
+			return created, fmt.Errorf("failed to create payout for referrer %d: %w", g.ReferrerUserID, err)
+		}
+		if ok {
+			created++
+		}
+	}
+	return created, nil
+}
+
+// createPayout creates one referrer's payout in one currency, reporting
+// whether the threshold was reached.
+func createPayout(db *gorm.DB, referrerID uint, currency string, period time.Time) (bool, error) {
+	tier, err := ResolveRule(db, referrerID)
+	if err != nil {
+		return false, err
+	}
+
+	created := false
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var pending []models.ReferralCommission
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("referrer_user_id = ? AND currency = ? AND status = ? AND payout_id IS NULL",
+				referrerID, currency, models.CommissionPending).
+			Find(&pending).Error; err != nil {
+			return err
+		}
+
+		var total int64
+		ids := make([]uint, 0, len(pending))
+		for _, c := range pending {
+			total += c.AmountCents - c.ReversedCents
+			ids = append(ids, c.ID)
+		}
+		if total <= 0 || total < tier.Rule.MinPayoutCents {
+			return nil
+		}
+
+		payout := models.ReferralPayout{
+			ReferrerUserID:  referrerID,
+			PeriodStart:     period,
+			Currency:        currency,
+			AmountCents:     total,
+			CommissionCount: len(pending),
+			Status:          models.PayoutPending,
+		}
+		// A rerun of the same period leaves the existing payout alone
+		res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&payout)
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		created = true
+		return tx.Model(&models.ReferralCommission{}).Where("id IN ?", ids).Update("payout_id", payout.ID).Error
+	})
+	return created, err
+}
+
+// PayDue transfers every payout that is new or due for a retry. It returns
+// how many were paid and how many failed.
+func PayDue(db *gorm.DB, now time.Time) (paid, failed int, err error) {
+	var due []models.ReferralPayout
+	if err := db.Where("status = ? OR (status = ? AND next_attempt_at <= ?)",
+		models.PayoutPending, models.PayoutRetrying, now).
+		Order("id").Find(&due).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to load due payouts: %w", err)
+	}
+
+	for _, payout := range due {
+		ok, err := Pay(db, payout.ID, now)
+		if err != nil {
+			return paid, failed, err
+		}
+		if ok {
+			paid++
+		} else {
+			failed++
+		}
+	}
+	return paid, failed, nil
+}
+
+// Pay transfers one payout. A failed transfer is recorded on the payout and
+// reported as false; the error is for database failures only.
+func Pay(db *gorm.DB, payoutID uint, now time.Time) (bool, error) {
+	paid := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the payout so concurrent runs can't both transfer it
+		var payout models.ReferralPayout
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, payoutID).Error; err != nil {
+			return err
+		}
+		if payout.Status == models.PayoutPaid || payout.Status == models.PayoutCancelled {
+			paid = payout.Status == models.PayoutPaid
+			return nil
+		}
+
+		var commissions []models.ReferralCommission
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("payout_id = ? AND status = ?", payout.ID, models.CommissionPending).
+			Order("id").Find(&commissions).Error; err != nil {
+			return err
+		}
+
+		// Commissions may have been reversed since the payout was created
+		var total int64
+		for _, c := range commissions {
+			total += c.AmountCents - c.ReversedCents
+		}
+		if total <= 0 {
+			return tx.Model(&payout).Updates(map[string]interface{}{
+				"status":          models.PayoutCancelled,
+				"amount_cents":    0,
+				"next_attempt_at": nil,
+			}).Error
+		}
+
+		var referrer models.User
+		if err := tx.First(&referrer, payout.ReferrerUserID).Error; err != nil {
+			return err
+		}
+
+		t, transferErr := transferPayout(payout, referrer, commissions, total)
+		if transferErr != nil {
+			return recordPayoutFailure(tx, payout, transferErr, now)
+		}
+
+		if err := tx.Model(&payout).Updates(map[string]interface{}{
+			"status":           models.PayoutPaid,
+			"transfer_id":      t.ID,
+			"amount_cents":     total,
+			"commission_count": len(commissions),
+			"attempts":         payout.Attempts + 1,
+			"last_error":       "",
+			"next_attempt_at":  nil,
+			"paid_at":          now,
+		}).Error; err != nil {
+			return err
+		}
+		// Commissions partly reversed before the transfer keep that status
+		for _, c := range commissions {
+			c.TransferID = t.ID
+			if err := tx.Model(&c).Updates(map[string]interface{}{
+				"status":      CommissionStatus(c, c.ReversedCents),
+				"transfer_id": t.ID,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		paid = true
+		return tx.Model(&referrer).
+			Update("referral_earnings_cents", gorm.Expr("referral_earnings_cents + ?", total)).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to pay payout %d: %w", payoutID, err)
+	}
+	return paid, nil
+}
+
+// transferPayout makes the payout's transfer, or finds the one an earlier
+// attempt made before failing to record it.
+func transferPayout(payout models.ReferralPayout, referrer models.User, commissions []models.ReferralCommission, total int64) (*stripe.Transfer, error) {
+	if !referrer.CanEarnReferrals() {
+		return nil, errNoConnectAccount
+	}
+	group := fmt.Sprintf("referral_payout_%d", payout.ID)
+
+	if payout.Attempts > 0 {
+		list := &stripe.TransferListParams{TransferGroup: stripe.String(group)}
+		list.Limit = stripe.Int64(1)
+		iter := transfer.List(list)
+		if iter.Next() {
+			return iter.Transfer(), nil
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	params := &stripe.TransferParams{
+		Amount:        stripe.Int64(total),
+		Currency:      stripe.String(payout.Currency),
+		Destination:   stripe.String(*referrer.StripeConnectAccountID),
+		Description:   stripe.String(fmt.Sprintf("Referral commissions (%d payments)", len(commissions))),
+		TransferGroup: stripe.String(group),
+		Metadata: map[string]string{
+			"referrer_user_id": fmt.Sprintf("%d", referrer.ID),
+			"payout_id":        fmt.Sprintf("%d", payout.ID),
+			"commissions":      fmt.Sprintf("%d", len(commissions)),
+		},
+	}
+	// A single commission is linked to its payment so the transfer doesn't
+	// depend on our available balance.
+	if len(commissions) == 1 && commissions[0].ChargeID != "" {
+		params.SourceTransaction = stripe.String(commissions[0].ChargeID)
+	}
+	// Stripe replays the first result for a key, errors included, so each
+	// attempt gets its own key; the transfer group lookup above covers an
+	// attempt whose transfer succeeded but wasn't recorded.
+	params.SetIdempotencyKey(fmt.Sprintf("referral-payout-%d-%d", payout.ID, payout.Attempts+1))
+	return transfer.New(params)
+}
+
+// recordPayoutFailure schedules a retry for temporary failures and marks the
+// payout failed otherwise.
+func recordPayoutFailure(tx *gorm.DB, payout models.ReferralPayout, transferErr error, now time.Time) error {
+	attempts := payout.Attempts + 1
+	updates := map[string]interface{}{
+		"attempts":        attempts,
+		"last_error":      transferErr.Error(),
+		"status":          models.PayoutFailed,
+		"next_attempt_at": nil,
+	}
+	if retryable(transferErr) && attempts < MaxPayoutAttempts {
+		delay := payoutRetryBase << (attempts - 1)
+		if delay > payoutRetryMax {
+			delay = payoutRetryMax
+		}
+		updates["status"] = models.PayoutRetrying
+		updates["next_attempt_at"] = now.Add(delay)
+	}
+	return tx.Model(&payout).Updates(updates).Error
+}
+
+// retryable reports whether a transfer error may go away on its own: the
+// referrer's account isn't ready for transfers yet, our balance hasn't
+// settled, or Stripe couldn't be reached.
+func retryable(err error) bool {
+	if errors.Is(err, errNoConnectAccount) {
+		return false
+	}
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return true
+	}
+	if stripeErr.HTTPStatusCode >= 500 || stripeErr.HTTPStatusCode == 429 || stripeErr.Type == stripe.ErrorTypeAPI {
+		return true
+	}
+	switch stripeErr.Code {
+	case stripe.ErrorCodeAccountInvalid,
+		stripe.ErrorCodeBalanceInsufficient,
+		stripe.ErrorCodeTransfersNotAllowed,
+		stripe.ErrorCode("insufficient_capabilities_for_transfer"):
+		return true
+	}
+	return false
+}
+
+// --- Admin Endpoints ---
+
+// ListPayouts lists payouts, newest first. Filter with ?status= (default
+// "failed") and cap with ?limit= (default 50).
+func (h *Handler) ListPayouts(c *gin.Context) {
+	status := c.DefaultQuery("status", models.PayoutFailed)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	var payouts []models.ReferralPayout
+	if err := h.DB.Where("status = ?", status).Order("created_at DESC").Limit(limit).Find(&payouts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payouts"})
+		return
+	}
+	c.JSON(http.StatusOK, payouts)
+}
+
+// RetryPayout tries a failed or retrying payout's transfer again now.
+func (h *Handler) RetryPayout(c *gin.Context) {
+	var payout models.ReferralPayout
+	if err := h.DB.First(&payout, c.Param("id")).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Payout not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+	if payout.Status != models.PayoutFailed && payout.Status != models.PayoutRetrying {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Payout is %s, only failed payouts can be retried", payout.Status)})
+		return
+	}
+
+	paid, err := Pay(h.DB, payout.ID, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.DB.First(&payout, payout.ID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"paid": paid, "payout": payout})
+}
@@ -0,0 +1,223 @@
+package referrals
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/form"
+	"gorm.io/gorm"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no connect account", errNoConnectAccount, false},
+		{"wrapped no connect account", fmt.Errorf("payout 1: %w", errNoConnectAccount), false},
+		{"network error", errors.New("connection reset by peer"), true},
+		{"server error", &stripe.Error{HTTPStatusCode: 500}, true},
+		{"rate limited", &stripe.Error{HTTPStatusCode: 429, Type: stripe.ErrorTypeInvalidRequest}, true},
+		{"api error", &stripe.Error{HTTPStatusCode: 400, Type: stripe.ErrorTypeAPI}, true},
+		{"account not ready", &stripe.Error{HTTPStatusCode: 400, Code: stripe.ErrorCodeAccountInvalid}, true},
+		{"balance not settled", &stripe.Error{HTTPStatusCode: 400, Code: stripe.ErrorCodeBalanceInsufficient}, true},
+		{"transfers not allowed", &stripe.Error{HTTPStatusCode: 400, Code: stripe.ErrorCodeTransfersNotAllowed}, true},
+		{"missing capabilities", &stripe.Error{HTTPStatusCode: 400, Code: "insufficient_capabilities_for_transfer"}, true},
+		{"wrapped stripe error", fmt.Errorf("transfer: %w", &stripe.Error{HTTPStatusCode: 503}), true},
+		{"invalid request", &stripe.Error{HTTPStatusCode: 400, Type: stripe.ErrorTypeInvalidRequest, Code: stripe.ErrorCodeParameterInvalidInteger}, false},
+		{"not found", &stripe.Error{HTTPStatusCode: 404, Code: stripe.ErrorCodeResourceMissing}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommissionStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		transferID string
+		reversed   int64
+		want       string
+	}{
+		{"pending", "", 0, models.CommissionPending},
+		{"pending partly reversed", "", 290, models.CommissionPending},
+		{"pending fully reversed", "", 580, models.CommissionReversed},
+		{"paid", "tr_1", 0, models.CommissionPaid},
+		{"paid partly reversed", "tr_1", 290, models.CommissionPartiallyReversed},
+		{"paid fully reversed", "tr_1", 580, models.CommissionReversed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := models.ReferralCommission{AmountCents: 580, TransferID: tt.transferID}
+			if got := CommissionStatus(c, tt.reversed); got != tt.want {
+				t.Errorf("CommissionStatus = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeTransfers stands in for the Stripe API, answering transfer requests
+// with tr_1 or failing them with err.
+type fakeTransfers struct {
+	err     error
+	created []*stripe.TransferParams
+}
+
+// useFakeTransfers routes Stripe API calls to a fake for the rest of the test.
+func useFakeTransfers(t *testing.T) *fakeTransfers {
+	t.Helper()
+	f := &fakeTransfers{}
+	previous := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, f)
+	t.Cleanup(func() { stripe.SetBackend(stripe.APIBackend, previous) })
+	return f
+}
+
+func (f *fakeTransfers) Call(method, path, key string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	if f.err != nil {
+		return f.err
+	}
+	if p, ok := params.(*stripe.TransferParams); ok && method == http.MethodPost {
+		f.created = append(f.created, p)
+	}
+	return json.Unmarshal([]byte(`{"id":"tr_1"}`), v)
+}
+
+func (f *fakeTransfers) CallStreaming(method, path, key string, params stripe.ParamsContainer, v stripe.StreamingLastResponseSetter) error {
+	return errors.New("fake Stripe: streaming not supported")
+}
+
+func (f *fakeTransfers) CallRaw(method, path, key string, body *form.Values, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return errors.New("fake Stripe: raw calls not supported")
+}
+
+func (f *fakeTransfers) CallMultipart(method, path, key, boundary string, body *bytes.Buffer, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return errors.New("fake Stripe: multipart calls not supported")
+}
+
+func (f *fakeTransfers) SetMaxNetworkRetries(int64) {}
+
+// createPendingPayout adds a referrer with a Connect account and a pending
+// payout of commissions with the given reversed amounts, 580 cents each.
+func createPendingPayout(t *testing.T, db *gorm.DB, reversed ...int64) (models.User, models.ReferralPayout) {
+	t.Helper()
+	account := "acct_1"
+	referrer := models.User{GoogleID: "g1", Email: "referrer@example.com", StripeConnectAccountID: &account}
+	if err := db.Create(&referrer).Error; err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range reversed {
+		c := models.ReferralCommission{
+			ReferrerUserID: referrer.ID,
+			ReferredUserID: 99,
+			InvoiceID:      fmt.Sprintf("in_%d", i+1),
+			AmountCents:    580,
+			ReversedCents:  r,
+			Currency:       "usd",
+			RateBps:        2000,
+			Status:         models.CommissionPending,
+		}
+		if err := db.Create(&c).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	period := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	if n, err := CreatePayouts(db, period); err != nil || n != 1 {
+		t.Fatalf("CreatePayouts = %d, %v; want one payout", n, err)
+	}
+	var payout models.ReferralPayout
+	db.First(&payout)
+	return referrer, payout
+}
+
+func TestPayKeepsPartialReversals(t *testing.T) {
+	db := newTestDB(t)
+	fake := useFakeTransfers(t)
+	referrer, payout := createPendingPayout(t, db, 0, 100)
+	now := time.Now().UTC()
+
+	paid, err := Pay(db, payout.ID, now)
+	if err != nil || !paid {
+		t.Fatalf("Pay = %v, %v; want paid", paid, err)
+	}
+	if len(fake.created) != 1 || *fake.created[0].Amount != 1060 {
+		t.Fatalf("transfers = %v, want one of 1060 cents", fake.created)
+	}
+
+	var commissions []models.ReferralCommission
+	db.Order("id").Find(&commissions)
+	want := []string{models.CommissionPaid, models.CommissionPartiallyReversed}
+	for i, c := range commissions {
+		if c.Status != want[i] || c.TransferID != "tr_1" {
+			t.Errorf("commission %d is %s on transfer %q, want %s on tr_1", c.ID, c.Status, c.TransferID, want[i])
+		}
+	}
+	db.First(&payout, payout.ID)
+	if payout.Status != models.PayoutPaid || payout.AmountCents != 1060 {
+		t.Errorf("payout is %s for %d cents, want paid for 1060", payout.Status, payout.AmountCents)
+	}
+	db.First(&referrer, referrer.ID)
+	if referrer.ReferralEarningsCents != 1060 {
+		t.Errorf("referrer earnings = %d, want 1060", referrer.ReferralEarningsCents)
+	}
+
+	// Paying again does nothing
+	if paid, err := Pay(db, payout.ID, now); err != nil || !paid || len(fake.created) != 1 {
+		t.Errorf("second Pay = %v, %v with %d transfers; want paid once", paid, err, len(fake.created))
+	}
+}
+
+func TestPayRetriesTemporaryFailures(t *testing.T) {
+	db := newTestDB(t)
+	fake := useFakeTransfers(t)
+	fake.err = &stripe.Error{HTTPStatusCode: 503}
+	_, payout := createPendingPayout(t, db, 0)
+	now := time.Now().UTC()
+
+	paid, err := Pay(db, payout.ID, now)
+	if err != nil || paid {
+		t.Fatalf("Pay = %v, %v; want a recorded failure", paid, err)
+	}
+	db.First(&payout, payout.ID)
+	if payout.Status != models.PayoutRetrying || payout.Attempts != 1 {
+		t.Errorf("payout is %s after %d attempts, want retrying after 1", payout.Status, payout.Attempts)
+	}
+	if payout.NextAttemptAt == nil || !payout.NextAttemptAt.Equal(now.Add(payoutRetryBase)) {
+		t.Errorf("next attempt at %v, want %v", payout.NextAttemptAt, now.Add(payoutRetryBase))
+	}
+	var commission models.ReferralCommission
+	db.First(&commission)
+	if commission.Status != models.CommissionPending || commission.TransferID != "" {
+		t.Errorf("commission is %s on transfer %q, want still pending", commission.Status, commission.TransferID)
+	}
+}
+
+func TestPayCancelsFullyReversedPayouts(t *testing.T) {
+	db := newTestDB(t)
+	fake := useFakeTransfers(t)
+	_, payout := createPendingPayout(t, db, 0)
+	db.Model(&models.ReferralCommission{}).Where("1 = 1").Update("reversed_cents", 580)
+
+	if _, err := Pay(db, payout.ID, time.Now().UTC()); err != nil {
+		t.Fatalf("Pay: %v", err)
+	}
+	if len(fake.created) != 0 {
+		t.Errorf("transferred %v for a fully reversed payout", fake.created)
+	}
+	db.First(&payout, payout.ID)
+	if payout.Status != models.PayoutCancelled {
+		t.Errorf("payout is %s, want %s", payout.Status, models.PayoutCancelled)
+	}
+}
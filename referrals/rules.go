@@ -0,0 +1,174 @@
+package referrals
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// --- Commission Rules ---
+// A referrer's commission comes from the commission_rules table. Rules with
+// a referrer_user_id override the global rules for that referrer. Within the
+// applicable set, the rule with the highest min_active_referrals the referrer
+// has reached is their tier.
+
+// defaultRule applies when no rules are configured at all.
+var defaultRule = models.CommissionRule{RateBps: 2000}
+
+// Tier is the commission rule that applies to a referrer right now.
+type Tier struct {
+	Rule            models.CommissionRule
+	Next            *models.CommissionRule // Next tier up, if any
+	ActiveReferrals int64
+	Override        bool // Rule is specific to this referrer
+}
+
+// ActiveReferrals counts the users a referrer brought in who currently have
+// an active or trialing subscription.
+func ActiveReferrals(db *gorm.DB, referrerID uint) (int64, error) {
+	var count int64
+	err := db.Model(&models.User{}).
+		Where("referred_by_user_id = ? AND subscription_status IN ?", referrerID, []string{"active", "trial"}).
+		Count(&count).Error
+	return count, err
+}
+
+// ResolveRule finds the commission tier of a referrer.
+func ResolveRule(db *gorm.DB, referrerID uint) (*Tier, error) {
+	active, err := ActiveReferrals(db, referrerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []models.CommissionRule
+	if err := db.Where("referrer_user_id = ?", referrerID).Order("min_active_referrals").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	override := len(rules) > 0
+	if !override {
+		if err := db.Where("referrer_user_id IS NULL").Order("min_active_referrals").Find(&rules).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return pickTier(rules, active, override), nil
+}
+
+// pickTier finds the tier a referrer with active referrals has reached among
+// rules, which are sorted by min_active_referrals.
+func pickTier(rules []models.CommissionRule, active int64, override bool) *Tier {
+	tier := &Tier{Rule: defaultRule, ActiveReferrals: active, Override: override}
+	for i, rule := range rules {
+		if int64(rule.MinActiveReferrals) > active {
+			tier.Next = &rules[i]
+			break
+		}
+		tier.Rule = rule
+	}
+	return tier
+}
+
+// InWindow reports whether a payment made at paidAt still earns commission
+// under rule, given when the referred user first paid. A zero firstPaidAt
+// means they haven't paid before.
+func InWindow(rule models.CommissionRule, firstPaidAt, paidAt time.Time) bool {
+	if rule.WindowMonths <= 0 || firstPaidAt.IsZero() {
+		return true
+	}
+	return paidAt.Before(firstPaidAt.AddDate(0, rule.WindowMonths, 0))
+}
+
+// CommissionCents applies a rule's rate to an amount.
+func CommissionCents(rule models.CommissionRule, amountCents int64) int64 {
+	return amountCents * int64(rule.RateBps) / 10000
+}
+
+// --- Admin Endpoints ---
+
+type CommissionRuleRequest struct {
+	ReferrerUserID     *uint `json:"referrer_user_id"`
+	RateBps            int   `json:"rate_bps" binding:"min=0,max=10000"`
+	MinActiveReferrals int   `json:"min_active_referrals" binding:"min=0"`
+	WindowMonths       int   `json:"window_months" binding:"min=0"`
+	MinPayoutCents     int64 `json:"min_payout_cents" binding:"min=0"`
+}
+
+// ListCommissionRules lists every rule, global rules first. Filter to one
+// referrer's overrides with ?referrer_user_id=.
+func (h *Handler) ListCommissionRules(c *gin.Context) {
+	query := h.DB.Order("referrer_user_id NULLS FIRST, min_active_referrals")
+	if referrer := c.Query("referrer_user_id"); referrer != "" {
+		query = query.Where("referrer_user_id = ?", referrer)
+	}
+
+	var rules []models.CommissionRule
+	if err := query.Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load commission rules"})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// PutCommissionRule creates a rule, or replaces the one with the same
+// referrer and tier threshold.
+func (h *Handler) PutCommissionRule(c *gin.Context) {
+	var req CommissionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.ReferrerUserID != nil {
+		if err := h.DB.First(&models.User{}, *req.ReferrerUserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Referrer not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			}
+			return
+		}
+	}
+
+	query := h.DB.Where("min_active_referrals = ?", req.MinActiveReferrals)
+	if req.ReferrerUserID != nil {
+		query = query.Where("referrer_user_id = ?", *req.ReferrerUserID)
+	} else {
+		query = query.Where("referrer_user_id IS NULL")
+	}
+
+	var rule models.CommissionRule
+	if err := query.First(&rule).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	rule.ReferrerUserID = req.ReferrerUserID
+	rule.RateBps = req.RateBps
+	rule.MinActiveReferrals = req.MinActiveReferrals
+	rule.WindowMonths = req.WindowMonths
+	rule.MinPayoutCents = req.MinPayoutCents
+
+	if err := h.DB.Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission rule"})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteCommissionRule removes a rule. Deleting all of a referrer's rules
+// puts them back on the global tiers.
+func (h *Handler) DeleteCommissionRule(c *gin.Context) {
+	res := h.DB.Delete(&models.CommissionRule{}, c.Param("id"))
+	if res.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete commission rule"})
+		return
+	}
+	if res.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": true})
+}
@@ -0,0 +1,104 @@
+package referrals
+
+import (
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+)
+
+func TestCommissionCents(t *testing.T) {
+	tests := []struct {
+		name    string
+		rateBps int
+		amount  int64
+		want    int64
+	}{
+		{"default rate", 2000, 2900, 580},
+		{"rounds down", 2000, 999, 199},
+		{"zero rate", 0, 2900, 0},
+		{"full rate", 10000, 2900, 2900},
+		{"fractional percent", 1250, 10000, 1250},
+		{"zero amount", 2000, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := models.CommissionRule{RateBps: tt.rateBps}
+			if got := CommissionCents(rule, tt.amount); got != tt.want {
+				t.Errorf("CommissionCents(%d bps, %d) = %d, want %d", tt.rateBps, tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	first := time.Date(2026, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		windowMonths int
+		firstPaidAt  time.Time
+		paidAt       time.Time
+		want         bool
+	}{
+		{"no window", 0, first, first.AddDate(5, 0, 0), true},
+		{"first payment", 12, time.Time{}, first, true},
+		{"same payment", 12, first, first, true},
+		{"inside window", 12, first, first.AddDate(0, 11, 0), true},
+		{"last moment", 12, first, first.AddDate(0, 12, 0).Add(-time.Second), true},
+		{"window end", 12, first, first.AddDate(0, 12, 0), false},
+		{"after window", 3, first, first.AddDate(1, 0, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := models.CommissionRule{WindowMonths: tt.windowMonths}
+			if got := InWindow(rule, tt.firstPaidAt, tt.paidAt); got != tt.want {
+				t.Errorf("InWindow(%d months, %v, %v) = %v, want %v", tt.windowMonths, tt.firstPaidAt, tt.paidAt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickTier(t *testing.T) {
+	rules := []models.CommissionRule{
+		{ID: 1, RateBps: 2000, MinActiveReferrals: 0},
+		{ID: 2, RateBps: 2500, MinActiveReferrals: 10},
+		{ID: 3, RateBps: 3000, MinActiveReferrals: 50},
+	}
+	tests := []struct {
+		name     string
+		rules    []models.CommissionRule
+		active   int64
+		wantRate int
+		wantNext uint // 0 means no next tier
+	}{
+		{"no rules", nil, 100, defaultRule.RateBps, 0},
+		{"base tier", rules, 0, 2000, 2},
+		{"below threshold", rules, 9, 2000, 2},
+		{"at threshold", rules, 10, 2500, 3},
+		{"top tier", rules, 50, 3000, 0},
+		{"above top tier", rules, 500, 3000, 0},
+		{"below lowest rule", rules[1:], 3, defaultRule.RateBps, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tier := pickTier(tt.rules, tt.active, false)
+			if tier.Rule.RateBps != tt.wantRate {
+				t.Errorf("rate = %d bps, want %d", tier.Rule.RateBps, tt.wantRate)
+			}
+			var next uint
+			if tier.Next != nil {
+				next = tier.Next.ID
+			}
+			if next != tt.wantNext {
+				t.Errorf("next tier = rule %d, want rule %d", next, tt.wantNext)
+			}
+			if tier.ActiveReferrals != tt.active {
+				t.Errorf("active referrals = %d, want %d", tier.ActiveReferrals, tt.active)
+			}
+		})
+	}
+
+	if tier := pickTier(rules, 0, true); !tier.Override {
+		t.Error("override rules not reported as an override")
+	}
+}
@@ -0,0 +1,160 @@
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ---
+// LEADER ELECTION
+// ---
+// Every scheduler replica keeps its cron entries registered so failover is
+// instant, but only the replica holding the Redis lease actually runs jobs.
+
+const (
+	// leaderKey is the Redis key holding the current leader's ID.
+	leaderKey = "scheduler:leader"
+
+	// DefaultLeaseTTL is how long a lease lasts without renewal.
+	DefaultLeaseTTL = 15 * time.Second
+)
+
+// renewScript extends the lease only if this replica still holds it.
+var renewScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// releaseScript deletes the lease only if this replica still holds it.
+var releaseScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// Leader competes for a Redis lease and reports whether it currently holds it.
+type Leader struct {
+	RDB *redis.Client
+	ID  string
+	TTL time.Duration
+
+	// OnAcquire, if set, is called in its own goroutine whenever this replica
+	// becomes leader.
+	OnAcquire func()
+
+	mu         sync.Mutex
+	validUntil time.Time // Local deadline after which we stop trusting the lease
+}
+
+// NewLeader creates a lease contender with a unique ID for this process.
+func NewLeader(rdb *redis.Client, ttl time.Duration) *Leader {
+	return &Leader{
+		RDB: rdb,
+		ID:  newInstanceID(),
+		TTL: ttl,
+	}
+}
+
+// IsLeader reports whether this replica holds an unexpired lease. It errs on
+// the side of "no": the local deadline expires before the one in Redis.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.validUntil)
+}
+
+// Run acquires and renews the lease until ctx is cancelled, then releases it
+// so another replica can take over immediately.
+func (l *Leader) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.TTL / 3)
+	defer ticker.Stop()
+
+	l.tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			l.release()
+			return
+		case <-ticker.C:
+			l.tick(ctx)
+		}
+	}
+}
+
+// tick renews the lease if we hold it, or tries to acquire it if we don't.
+func (l *Leader) tick(ctx context.Context) {
+	start := time.Now()
+	wasLeader := l.IsLeader()
+
+	var held bool
+	var err error
+	if wasLeader {
+		var n int64
+		n, err = renewScript.Run(ctx, l.RDB, []string{leaderKey}, l.ID, l.TTL.Milliseconds()).Int64()
+		held = n == 1
+	} else {
+		held, err = l.RDB.SetNX(ctx, leaderKey, l.ID, l.TTL).Result()
+	}
+	if err != nil {
+		log.Printf("Error refreshing scheduler lease: %v", err)
+	}
+
+	l.mu.Lock()
+	switch {
+	case err != nil:
+		// Keep whatever lease we had; it lapses locally if Redis stays unreachable.
+	case held:
+		// Measure from before the round trip and keep a safety margin so we
+		// never believe we lead after Redis has expired the key.
+		l.validUntil = start.Add(l.TTL * 2 / 3)
+	default:
+		l.validUntil = time.Time{}
+	}
+	l.mu.Unlock()
+
+	if isLeader := l.IsLeader(); isLeader != wasLeader {
+		if isLeader {
+			log.Printf("Scheduler %s acquired leadership", l.ID)
+			if l.OnAcquire != nil {
+				go l.OnAcquire()
+			}
+		} else {
+			log.Printf("Scheduler %s lost leadership", l.ID)
+		}
+	}
+}
+
+// release gives up the lease if we still hold it.
+func (l *Leader) release() {
+	l.mu.Lock()
+	l.validUntil = time.Time{}
+	l.mu.Unlock()
+
+	if err := releaseScript.Run(context.Background(), l.RDB, []string{leaderKey}, l.ID).Err(); err != nil {
+		log.Printf("Error releasing scheduler lease: %v", err)
+	}
+}
+
+// newInstanceID identifies this scheduler process in the lease.
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "scheduler"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
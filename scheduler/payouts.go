@@ -0,0 +1,99 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/referrals"
+)
+
+// ---
+// REFERRAL PAYOUTS
+// ---
+// Each payout run batches referrers' pending commissions into payouts and
+// transfers them. Between runs, payouts whose transfer failed are retried
+// once their backoff has passed. Like series slots, both jobs only run on the
+// leader, and a run is claimed in Redis so a failover can't repeat it.
+
+const (
+	// DefaultPayoutSchedule runs payouts every Monday at 09:00.
+	DefaultPayoutSchedule = "0 9 * * 1"
+
+	// payoutRetrySchedule is how often failed transfers are checked for retry.
+	payoutRetrySchedule = "@hourly"
+)
+
+// schedulePayouts registers the payout and retry jobs.
+func (s *Scheduler) schedulePayouts() error {
+	if _, err := s.Cron.AddFunc(s.PayoutSchedule, s.runPayouts); err != nil {
+		return fmt.Errorf("invalid payout schedule %q: %w", s.PayoutSchedule, err)
+	}
+	if _, err := s.Cron.AddFunc(payoutRetrySchedule, s.retryPayouts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// runPayouts creates this period's payouts and transfers everything due.
+func (s *Scheduler) runPayouts() {
+	ctx := context.Background()
+	if !s.Leader.IsLeader() {
+		return
+	}
+
+	period := time.Now().UTC().Truncate(time.Minute)
+	claimed, err := s.claimRun(ctx, "payouts", period)
+	if err != nil {
+		log.Printf("Error claiming payout run %s: %v", period.Format(time.RFC3339), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+
+	created, err := referrals.CreatePayouts(s.DB, period)
+	if err != nil {
+		// Payouts created before the error are still paid below
+		log.Printf("Error creating referral payouts: %v", err)
+	}
+	log.Printf("Created %d referral payouts for period %s", created, period.Format(time.RFC3339))
+	s.payDue(period)
+}
+
+// retryPayouts transfers payouts whose retry is due.
+func (s *Scheduler) retryPayouts() {
+	ctx := context.Background()
+	if !s.Leader.IsLeader() {
+		return
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	claimed, err := s.claimRun(ctx, "payout-retries", now)
+	if err != nil {
+		log.Printf("Error claiming payout retry run %s: %v", now.Format(time.RFC3339), err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	s.payDue(now)
+}
+
+// payDue transfers due payouts and logs the outcome.
+func (s *Scheduler) payDue(now time.Time) {
+	paid, failed, err := referrals.PayDue(s.DB, now)
+	if err != nil {
+		log.Printf("Error paying referral payouts: %v", err)
+	}
+	if paid > 0 || failed > 0 {
+		log.Printf("Referral payouts: %d paid, %d failed", paid, failed)
+	}
+}
+
+// claimRun records that a job's run is being handled, like claimSlot.
+func (s *Scheduler) claimRun(ctx context.Context, job string, at time.Time) (bool, error) {
+	key := fmt.Sprintf("scheduler:%s:%d", job, at.Unix())
+	return s.RDB.SetNX(ctx, key, s.Leader.ID, slotTTL).Result()
+}
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/robfig/cron/v3"
+)
+
+// ---
+// SERIES SCHEDULES
+// ---
+// A series is turned into one or more standard 5-field cron specs prefixed
+// with CRON_TZ, each of which generates a single video when it fires.
+
+// weekdays maps accepted day names to their cron day-of-week number.
+var weekdays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// Specs returns the cron specs that implement a series' posting schedule.
+// It doubles as validation for the series API.
+func Specs(series models.Series) ([]string, error) {
+	tz := series.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", tz)
+	}
+
+	if expr := strings.TrimSpace(series.ScheduleCron); expr != "" {
+		if strings.HasPrefix(expr, "@") || strings.Contains(expr, "TZ=") {
+			return nil, fmt.Errorf("cron expression must be a standard 5-field expression without descriptors or time zone")
+		}
+		spec := fmt.Sprintf("CRON_TZ=%s %s", tz, expr)
+		if _, err := cron.ParseStandard(spec); err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+		return []string{spec}, nil
+	}
+
+	if series.PostsPerDay < 1 {
+		return nil, fmt.Errorf("posts per day must be at least 1")
+	}
+
+	start, err := parseClock(series.WindowStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window start: %w", err)
+	}
+	end, err := parseClock(series.WindowEnd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid window end: %w", err)
+	}
+	if end <= start {
+		return nil, fmt.Errorf("window end must be after window start")
+	}
+
+	dow, err := daysOfWeekField(series.DaysOfWeek)
+	if err != nil {
+		return nil, err
+	}
+
+	// Place each post in the middle of an equal share of the window,
+	// e.g. 3 posts over 09:00-21:00 go out at 11:00, 15:00 and 19:00.
+	window := end - start
+	if window < series.PostsPerDay {
+		return nil, fmt.Errorf("window is too short for %d posts per day", series.PostsPerDay)
+	}
+	step := window / series.PostsPerDay
+
+	specs := make([]string, 0, series.PostsPerDay)
+	for i := 0; i < series.PostsPerDay; i++ {
+		minute := start + step*i + step/2
+		specs = append(specs, fmt.Sprintf("CRON_TZ=%s %d %d * * %s", tz, minute%60, minute/60, dow))
+	}
+	return specs, nil
+}
+
+// PostsPerDay returns how many times a set of specs fires on a day it posts,
+// so a cron expression can be held to the plan's posts-per-day limit.
+func PostsPerDay(specs []string) (int, error) {
+	total := 0
+	for _, spec := range specs {
+		sched, err := cron.ParseStandard(spec)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cron expression: %w", err)
+		}
+		// Count the fires on the day of the next one. The minute and hour
+		// fields are the same every day, so any posting day will do.
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			continue
+		}
+		day := time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, next.Location())
+		end := day.AddDate(0, 0, 1)
+		for t := sched.Next(day.Add(-time.Second)); !t.IsZero() && t.Before(end); t = sched.Next(t) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not in HH:MM format", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// daysOfWeekField builds the cron day-of-week field; no days means every day.
+func daysOfWeekField(days []string) (string, error) {
+	if len(days) == 0 {
+		return "*", nil
+	}
+
+	var set [7]bool
+	for _, day := range days {
+		n, ok := weekdays[strings.ToLower(strings.TrimSpace(day))]
+		if !ok {
+			return "", fmt.Errorf("unknown day of week %q", day)
+		}
+		set[n] = true
+	}
+
+	var fields []string
+	for n, ok := range set {
+		if ok {
+			fields = append(fields, fmt.Sprintf("%d", n))
+		}
+	}
+	return strings.Join(fields, ","), nil
+}
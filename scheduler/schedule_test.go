@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/robfig/cron/v3"
+)
+
+func TestSpecs(t *testing.T) {
+	window := func(posts int, start, end, tz string, days ...string) models.Series {
+		return models.Series{PostsPerDay: posts, WindowStart: start, WindowEnd: end, TimeZone: tz, DaysOfWeek: days}
+	}
+	tests := []struct {
+		name   string
+		series models.Series
+		want   []string
+	}{
+		{
+			"spread over window",
+			window(3, "09:00", "21:00", "America/New_York"),
+			[]string{
+				"CRON_TZ=America/New_York 0 11 * * *",
+				"CRON_TZ=America/New_York 0 15 * * *",
+				"CRON_TZ=America/New_York 0 19 * * *",
+			},
+		},
+		{
+			"single post mid-window",
+			window(1, "08:30", "09:30", "Europe/London"),
+			[]string{"CRON_TZ=Europe/London 0 9 * * *"},
+		},
+		{
+			"uneven minutes",
+			window(2, "10:00", "10:45", "UTC"),
+			[]string{"CRON_TZ=UTC 11 10 * * *", "CRON_TZ=UTC 33 10 * * *"},
+		},
+		{
+			"days of week",
+			window(1, "09:00", "11:00", "Asia/Tokyo", "Fri", " mon", "wed", "mon"),
+			[]string{"CRON_TZ=Asia/Tokyo 0 10 * * 1,3,5"},
+		},
+		{
+			"default time zone",
+			window(1, "09:00", "11:00", ""),
+			[]string{"CRON_TZ=UTC 0 10 * * *"},
+		},
+		{
+			"cron expression",
+			models.Series{ScheduleCron: " 30 7 * * 1-5 ", TimeZone: "Australia/Sydney", PostsPerDay: 3},
+			[]string{"CRON_TZ=Australia/Sydney 30 7 * * 1-5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Specs(tt.series)
+			if err != nil {
+				t.Fatalf("Specs: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Specs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecsRejectsInvalidSchedules(t *testing.T) {
+	valid := models.Series{PostsPerDay: 1, WindowStart: "09:00", WindowEnd: "21:00", TimeZone: "UTC"}
+	tests := []struct {
+		name   string
+		change func(*models.Series)
+	}{
+		{"unknown time zone", func(s *models.Series) { s.TimeZone = "Mars/Olympus" }},
+		{"bad window start", func(s *models.Series) { s.WindowStart = "9am" }},
+		{"bad window end", func(s *models.Series) { s.WindowEnd = "25:00" }},
+		{"window ends before it starts", func(s *models.Series) { s.WindowEnd = "08:00" }},
+		{"empty window", func(s *models.Series) { s.WindowEnd = s.WindowStart }},
+		{"window too short", func(s *models.Series) { s.PostsPerDay = 3; s.WindowEnd = "09:02" }},
+		{"no posts", func(s *models.Series) { s.PostsPerDay = 0 }},
+		{"unknown day", func(s *models.Series) { s.DaysOfWeek = []string{"funday"} }},
+		{"invalid cron", func(s *models.Series) { s.ScheduleCron = "61 * * * *" }},
+		{"cron descriptor", func(s *models.Series) { s.ScheduleCron = "@hourly" }},
+		{"cron time zone", func(s *models.Series) { s.ScheduleCron = "TZ=UTC 0 9 * * *" }},
+		{"six fields", func(s *models.Series) { s.ScheduleCron = "0 0 9 * * *" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series := valid
+			tt.change(&series)
+			if specs, err := Specs(series); err == nil {
+				t.Errorf("Specs accepted the schedule: %q", specs)
+			}
+		})
+	}
+}
+
+func TestSpecsFireInSeriesTimeZone(t *testing.T) {
+	series := models.Series{PostsPerDay: 1, WindowStart: "09:00", WindowEnd: "11:00", TimeZone: "America/New_York"}
+	specs, err := Specs(series)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sched, err := cron.ParseStandard(specs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10:00 in New York is 15:00 UTC in winter and 14:00 UTC in summer
+	tests := []struct {
+		from time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 15, 15, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 7, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 7, 15, 14, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := sched.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("next post after %s = %s, want %s", tt.from, got.UTC(), tt.want)
+		}
+	}
+}
+
+func TestPostsPerDay(t *testing.T) {
+	tests := []struct {
+		name  string
+		specs []string
+		want  int
+	}{
+		{"once a day", []string{"CRON_TZ=UTC 0 9 * * *"}, 1},
+		{"listed hours", []string{"CRON_TZ=America/New_York 0 9,12,18 * * *"}, 3},
+		{"some weekdays", []string{"CRON_TZ=UTC 30 8,20 * * 1,3,5"}, 2},
+		{"every five minutes", []string{"CRON_TZ=UTC */5 * * * *"}, 288},
+		{"hourly in a range", []string{"CRON_TZ=UTC 0 9-17 * * *"}, 9},
+		{"monthly", []string{"CRON_TZ=UTC 0 9 1 * *"}, 1},
+		{"window specs", []string{"CRON_TZ=UTC 0 11 * * *", "CRON_TZ=UTC 0 15 * * *", "CRON_TZ=UTC 0 19 * * *"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PostsPerDay(tt.specs)
+			if err != nil {
+				t.Fatalf("PostsPerDay(%v): %v", tt.specs, err)
+			}
+			if got != tt.want {
+				t.Errorf("PostsPerDay(%v) = %d, want %d", tt.specs, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := PostsPerDay([]string{"not a cron"}); err == nil {
+		t.Error("PostsPerDay accepted an invalid spec")
+	}
+}
@@ -0,0 +1,314 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/events"
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/drewmudry/instashorts-api/tasks"
+	"github.com/go-redis/redis/v8"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// consumerGroup is the scheduler's consumer group on the series event stream.
+const consumerGroup = "scheduler"
+
+// consumerName is the scheduler's name within its group. Only the leader
+// consumes, so every replica shares one stable name and a new leader picks up
+// whatever the last one left pending.
+const consumerName = "leader"
+
+// slotTTL is how long a claimed slot is remembered. It only needs to outlive
+// any plausible failover window.
+const slotTTL = 48 * time.Hour
+
+// slotTaskTTL is how long a scheduled video's first task may wait in the queue.
+// After a long worker outage, stale slots expire instead of all posting at once.
+const slotTaskTTL = time.Hour
+
+// DefaultReconcileInterval is how often the scheduler re-syncs its jobs with the database.
+const DefaultReconcileInterval = 5 * time.Minute
+
+// seriesJobs are the cron entries registered for one series.
+type seriesJobs struct {
+	entryIDs []cron.EntryID
+	specs    string // Joined specs, to detect schedule changes
+}
+
+// Scheduler owns the cron jobs that generate videos for each series.
+// The database is the source of truth: jobs are rebuilt from it on startup and
+// periodically reconciled. Series events keep the jobs current in between.
+//
+// Several replicas may run at once. Only the one holding the Leader lease
+// fires jobs and consumes series events, reconciling as soon as it takes over
+// so it starts from fresh state. Each series/slot pair is claimed in Redis
+// before any video is created, so a slot is never enqueued twice, even
+// across a failover.
+type Scheduler struct {
+	DB     *gorm.DB
+	RDB    *redis.Client
+	Cron   *cron.Cron
+	Leader *Leader
+
+	// PayoutSchedule is the cron spec for referral payout runs.
+	PayoutSchedule string
+
+	mu      sync.Mutex
+	entries map[uint]seriesJobs // Series ID -> cron entries
+}
+
+// New creates a scheduler. Call Start to begin firing jobs.
+func New(db *gorm.DB, rdb *redis.Client) *Scheduler {
+	return &Scheduler{
+		DB:             db,
+		RDB:            rdb,
+		Cron:           cron.New(),
+		Leader:         NewLeader(rdb, DefaultLeaseTTL),
+		PayoutSchedule: DefaultPayoutSchedule,
+		entries:        make(map[uint]seriesJobs),
+	}
+}
+
+// Start loads every active series and the payout jobs, starts the cron runner
+// and keeps the jobs in sync with the database until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context, reconcileInterval time.Duration) error {
+	if err := s.Reconcile(ctx); err != nil {
+		return err
+	}
+	if err := s.schedulePayouts(); err != nil {
+		return err
+	}
+	s.Cron.Start()
+
+	s.Leader.OnAcquire = func() {
+		if err := s.Reconcile(ctx); err != nil {
+			log.Printf("Error reconciling schedules after taking leadership: %v", err)
+		}
+	}
+	go s.Leader.Run(ctx)
+	go s.reconcileLoop(ctx, reconcileInterval)
+	go s.consumeEvents(ctx)
+	return nil
+}
+
+// Stop stops firing new jobs. The returned context is done once running jobs finish.
+func (s *Scheduler) Stop() context.Context {
+	return s.Cron.Stop()
+}
+
+// Reconcile makes the registered jobs match the active series in the database:
+// missing series are scheduled, changed schedules are replaced and inactive
+// or deleted series are removed.
+func (s *Scheduler) Reconcile(ctx context.Context) error {
+	var active []models.Series
+	if err := s.DB.WithContext(ctx).Where("is_active = ?", true).Find(&active).Error; err != nil {
+		return err
+	}
+
+	want := make(map[uint]bool, len(active))
+	changed := 0
+	for _, series := range active {
+		want[series.ID] = true
+		if s.schedule(series) {
+			changed++
+		}
+	}
+
+	removed := 0
+	s.mu.Lock()
+	for seriesID := range s.entries {
+		if !want[seriesID] {
+			s.unscheduleLocked(seriesID)
+			removed++
+		}
+	}
+	total := len(s.entries)
+	s.mu.Unlock()
+
+	if changed > 0 || removed > 0 {
+		log.Printf("Reconciled schedules: %d added or changed, %d removed, %d total", changed, removed, total)
+	}
+	return nil
+}
+
+// syncSeries reloads one series and brings its jobs up to date.
+func (s *Scheduler) syncSeries(ctx context.Context, seriesID uint) error {
+	var series models.Series
+	err := s.DB.WithContext(ctx).First(&series, seriesID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if err != nil || !series.IsActive {
+		s.mu.Lock()
+		s.unscheduleLocked(seriesID)
+		s.mu.Unlock()
+		return nil
+	}
+	s.schedule(series)
+	return nil
+}
+
+// schedule registers the jobs for a series, replacing them if its schedule
+// changed. It reports whether anything was (re)registered.
+func (s *Scheduler) schedule(series models.Series) bool {
+	specs, err := Specs(series)
+	if err != nil {
+		log.Printf("Error building schedule for series %d: %v", series.ID, err)
+		return false
+	}
+	joined := strings.Join(specs, "\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if jobs, ok := s.entries[series.ID]; ok {
+		if jobs.specs == joined {
+			return false
+		}
+		s.unscheduleLocked(series.ID)
+	}
+
+	seriesID := series.ID
+	jobs := seriesJobs{specs: joined}
+	for _, spec := range specs {
+		entryID, err := s.Cron.AddFunc(spec, func() { s.runSlot(seriesID) })
+		if err != nil {
+			log.Printf("Error scheduling cron job %q for series %d: %v", spec, seriesID, err)
+			continue
+		}
+		jobs.entryIDs = append(jobs.entryIDs, entryID)
+	}
+	s.entries[seriesID] = jobs
+	log.Printf("Scheduled series %d with %d slots", seriesID, len(jobs.entryIDs))
+	return true
+}
+
+// unscheduleLocked removes a series' jobs. The caller must hold s.mu.
+func (s *Scheduler) unscheduleLocked(seriesID uint) {
+	jobs, ok := s.entries[seriesID]
+	if !ok {
+		return
+	}
+	for _, entryID := range jobs.entryIDs {
+		s.Cron.Remove(entryID)
+	}
+	delete(s.entries, seriesID)
+	log.Printf("Unscheduled series %d", seriesID)
+}
+
+// runSlot is the cron job body: it queues one video for the series.
+func (s *Scheduler) runSlot(seriesID uint) {
+	// Jobs use their own context so a shutdown signal doesn't abort
+	// them halfway between creating a video and queueing it.
+	ctx := context.Background()
+
+	if !s.Leader.IsLeader() {
+		return
+	}
+
+	// Cron fires on minute boundaries, so the minute identifies the slot
+	// across replicas.
+	slot := time.Now().UTC().Truncate(time.Minute)
+	claimed, err := s.claimSlot(ctx, seriesID, slot)
+	if err != nil {
+		log.Printf("Error claiming slot %s for series %d: %v", slot.Format(time.RFC3339), seriesID, err)
+		return
+	}
+	if !claimed {
+		log.Printf("Slot %s for series %d already handled, skipping", slot.Format(time.RFC3339), seriesID)
+		return
+	}
+
+	// Re-read the series so edits made since scheduling are respected.
+	var series models.Series
+	if err := s.DB.First(&series, seriesID).Error; err != nil {
+		log.Printf("Error loading series %d for scheduled job: %v", seriesID, err)
+		return
+	}
+	if !series.IsActive {
+		log.Printf("Skipping scheduled job for inactive series %d", seriesID)
+		return
+	}
+
+	_, ent, err := plans.Load(s.DB, series.UserID)
+	if err != nil {
+		log.Printf("Error loading plan for series %d: %v", seriesID, err)
+		return
+	}
+	remaining, err := plans.RemainingVideos(s.DB, series.UserID, ent, time.Now())
+	if err != nil {
+		log.Printf("Error checking video quota for series %d: %v", seriesID, err)
+		return
+	}
+	if remaining == 0 {
+		log.Printf("Skipping scheduled job for series %d: user %d has used the %d monthly videos of the %s plan", seriesID, series.UserID, ent.MaxVideosPerMonth, ent.Plan)
+		return
+	}
+
+	log.Printf("Running scheduled slot %s for series %d", slot.Format(time.RFC3339), series.ID)
+
+	video := models.Video{
+		SeriesID: series.ID,
+		Status:   "pending",
+	}
+	if err := s.DB.Create(&video).Error; err != nil {
+		log.Printf("Error creating scheduled pending video record: %v", err)
+		return
+	}
+
+	task := tasks.TitleTaskPayload{VideoID: video.ID}
+	if err := tasks.Enqueue(ctx, s.RDB, tasks.QueueVideoTitle, task, tasks.WithDeadline(slot.Add(slotTaskTTL))); err != nil {
+		log.Printf("Error pushing scheduled task to queue %s: %v", tasks.QueueVideoTitle, err)
+	}
+}
+
+// claimSlot records that a series/slot pair is being handled. It reports false
+// if another run (possibly on another replica) already claimed it.
+func (s *Scheduler) claimSlot(ctx context.Context, seriesID uint, slot time.Time) (bool, error) {
+	key := fmt.Sprintf("scheduler:slot:%d:%d", seriesID, slot.Unix())
+	return s.RDB.SetNX(ctx, key, s.Leader.ID, slotTTL).Result()
+}
+
+// reconcileLoop re-syncs jobs with the database every interval.
+func (s *Scheduler) reconcileLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reconcile(ctx); err != nil {
+				log.Printf("Error reconciling schedules: %v", err)
+			}
+		}
+	}
+}
+
+// consumeEvents applies series events to the jobs so changes take effect
+// immediately instead of waiting for the next reconcile.
+func (s *Scheduler) consumeEvents(ctx context.Context) {
+	consumer := events.NewConsumer(s.RDB, events.SeriesStream, consumerGroup, consumerName)
+	consumer.Active = s.Leader.IsLeader
+
+	log.Println("Scheduler consuming series events...")
+	err := consumer.Run(ctx, func(ctx context.Context, evt events.Event) error {
+		log.Printf("Received %s for series %d", evt.Type, evt.SeriesID)
+		// Every event is handled the same way: syncSeries reloads the series
+		// and drops its jobs if it is paused or gone.
+		return s.syncSeries(ctx, evt.SeriesID)
+	})
+	if err != nil {
+		log.Printf("Error consuming series events: %v", err)
+	}
+}
@@ -2,12 +2,16 @@
 package series
 
 import (
-	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/drewmudry/instashorts-api/events"
 	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/drewmudry/instashorts-api/scheduler"
 	"github.com/drewmudry/instashorts-api/tasks"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -15,26 +19,117 @@ import (
 )
 
 type Handler struct {
-	DB    *gorm.DB
-	Redis *redis.Client
+	DB     *gorm.DB
+	Redis  *redis.Client
+	Events *events.Bus
 }
 
 func NewHandler(db *gorm.DB, rdb *redis.Client) *Handler {
-	return &Handler{DB: db, Redis: rdb}
+	return &Handler{DB: db, Redis: rdb, Events: events.NewBus(rdb)}
 }
 
 type CreateSeriesRequest struct {
 	Title       string `json:"title" binding:"required"`
 	Description string `json:"description"`
 	PostsPerDay int    `json:"posts_per_day" binding:"required,min=1,max=3"`
+
+	// Optional posting schedule; defaults to the model defaults.
+	Schedule *ScheduleRequest `json:"schedule"`
+
+	// Optional style profile; unset fields use the generator's defaults.
+	Style *StyleRequest `json:"style"`
 }
 
-type SeriesCreatedMessage struct {
-	SeriesID    uint `json:"series_id"`
-	PostsPerDay int  `json:"posts_per_day"`
+// StyleRequest sets a series' style profile. Omitted fields are left unchanged;
+// send an empty string or list to clear one.
+type StyleRequest struct {
+	VisualStyle     *string  `json:"visual_style" binding:"omitempty,max=200"`
+	ColorGrading    *string  `json:"color_grading" binding:"omitempty,max=200"`
+	ToneOfVoice     *string  `json:"tone_of_voice" binding:"omitempty,max=200"`
+	TargetAudience  *string  `json:"target_audience" binding:"omitempty,max=200"`
+	NarratorPersona *string  `json:"narrator_persona" binding:"omitempty,max=200"`
+	BannedTopics    []string `json:"banned_topics" binding:"omitempty,max=20,dive,max=100"`
+}
+
+// ScheduleRequest describes when a series posts. Either set CronExpression, or
+// leave it empty to spread posts_per_day across the window.
+type ScheduleRequest struct {
+	CronExpression string   `json:"cron_expression"`
+	WindowStart    string   `json:"window_start"` // HH:MM
+	WindowEnd      string   `json:"window_end"`   // HH:MM
+	TimeZone       string   `json:"time_zone"`    // IANA name, e.g. "America/New_York"
+	DaysOfWeek     []string `json:"days_of_week"` // e.g. ["mon","wed","fri"]; empty means every day
+}
+
+// UpdateScheduleRequest replaces a series' schedule.
+type UpdateScheduleRequest struct {
+	ScheduleRequest
+	PostsPerDay int `json:"posts_per_day" binding:"omitempty,min=1,max=3"`
+}
+
+// UpdateSeriesRequest changes a series' details or pauses/resumes it.
+// Omitted fields are left unchanged.
+type UpdateSeriesRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"is_active"`
+
+	Style *StyleRequest `json:"style"`
+}
+
+// applySchedule copies a schedule request onto a series, keeping the current
+// window bounds and time zone when they are omitted.
+func applySchedule(series *models.Series, req ScheduleRequest) {
+	series.ScheduleCron = req.CronExpression
+	if req.WindowStart != "" {
+		series.WindowStart = req.WindowStart
+	}
+	if req.WindowEnd != "" {
+		series.WindowEnd = req.WindowEnd
+	}
+	if req.TimeZone != "" {
+		series.TimeZone = req.TimeZone
+	}
+	series.DaysOfWeek = req.DaysOfWeek
+}
+
+// checkSchedulePosts holds a schedule to the plan's posts-per-day limit,
+// which a cron expression could otherwise exceed, and responds if it's over.
+func checkSchedulePosts(c *gin.Context, ent plans.Entitlements, specs []string) bool {
+	posts, err := scheduler.PostsPerDay(specs)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err := plans.CheckPostsPerDay(ent, posts); err != nil {
+		respondQuotaError(c, err)
+		return false
+	}
+	return true
 }
 
-const seriesCreatedChannel = "series_created"
+// applyStyle copies the fields set in a style request onto a series.
+func applyStyle(series *models.Series, req StyleRequest) {
+	style := &series.Style
+	if req.VisualStyle != nil {
+		style.VisualStyle = *req.VisualStyle
+	}
+	if req.ColorGrading != nil {
+		style.ColorGrading = *req.ColorGrading
+	}
+	if req.ToneOfVoice != nil {
+		style.ToneOfVoice = *req.ToneOfVoice
+	}
+	if req.TargetAudience != nil {
+		style.TargetAudience = *req.TargetAudience
+	}
+	if req.NarratorPersona != nil {
+		style.NarratorPersona = *req.NarratorPersona
+	}
+	if req.BannedTopics != nil {
+		style.BannedTopics = req.BannedTopics
+	}
+}
 
 func (h *Handler) CreateSeries(c *gin.Context) {
 	userID := c.GetUint("user_id")
@@ -50,6 +145,38 @@ func (h *Handler) CreateSeries(c *gin.Context) {
 		Description: req.Description,
 		PostsPerDay: req.PostsPerDay,
 		IsActive:    true, //
+		WindowStart: "09:00",
+		WindowEnd:   "21:00",
+		TimeZone:    "UTC",
+	}
+	if req.Schedule != nil {
+		applySchedule(&series, *req.Schedule)
+	}
+	if req.Style != nil {
+		applyStyle(&series, *req.Style)
+	}
+	specs, err := scheduler.Specs(series)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	_, ent, err := plans.Load(h.DB, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load plan"})
+		return
+	}
+	if err := plans.CheckNewSeries(h.DB, userID, ent, series.PostsPerDay); err != nil {
+		respondQuotaError(c, err)
+		return
+	}
+	if !checkSchedulePosts(c, ent, specs) {
+		return
+	}
+	remaining, err := plans.RemainingVideos(h.DB, userID, ent, time.Now())
+	if err != nil {
+		respondQuotaError(c, err)
+		return
 	}
 
 	if err := h.DB.Create(&series).Error; err != nil {
@@ -57,7 +184,15 @@ func (h *Handler) CreateSeries(c *gin.Context) {
 		return
 	}
 
-	for i := 0; i < series.PostsPerDay; i++ {
+	// Kick off the first day's videos, as far as the monthly quota allows
+	initial := int64(series.PostsPerDay)
+	if remaining < initial {
+		log.Printf("Monthly video quota leaves room for %d of %d initial videos for series %d", remaining, initial, series.ID)
+		initial = remaining
+	}
+
+	ctx := tasks.WithCorrelationID(c.Request.Context(), c.GetString("request_id"))
+	for i := int64(0); i < initial; i++ {
 		// 1. Create the 'pending' video record in the database
 		video := models.Video{
 			SeriesID: series.ID,
@@ -68,17 +203,10 @@ func (h *Handler) CreateSeries(c *gin.Context) {
 			continue // Don't fail the whole request
 		}
 
-		// 2. Publish a task for the worker to process this specific video
-		//    USING THE NEW TASK DEFINITION
-		task := tasks.TitleTaskPayload{VideoID: video.ID} // <-- USE NEW PAYLOAD
-		payload, err := json.Marshal(task)
-		if err != nil {
-			log.Printf("Error marshalling video task: %v", err)
-			continue
-		}
-
-		//    USING THE NEW QUEUE NAME
-		err = h.Redis.LPush(c.Request.Context(), tasks.QueueVideoTitle, payload).Err() // <-- USE NEW QUEUE
+		// 2. Publish a task for the worker to process this specific video,
+		//    tagged with the request ID so its whole pipeline can be traced
+		task := tasks.TitleTaskPayload{VideoID: video.ID}
+		err := tasks.Enqueue(ctx, h.Redis, tasks.QueueVideoTitle, task)
 		if err != nil {
 			log.Printf("Error publishing to %s: %v", tasks.QueueVideoTitle, err)
 		} else {
@@ -86,24 +214,200 @@ func (h *Handler) CreateSeries(c *gin.Context) {
 		}
 	}
 
-	// Publish message to Redis for the *daily scheduler*
-	message := SeriesCreatedMessage{
-		SeriesID:    series.ID,
-		PostsPerDay: series.PostsPerDay,
+	// Let the scheduler know about the new series
+	h.publish(c, events.SeriesCreated, &series)
+
+	c.JSON(http.StatusOK, series)
+}
+
+// UpdateSeriesSchedule replaces the posting schedule of one of the user's series.
+func (h *Handler) UpdateSeriesSchedule(c *gin.Context) {
+	series, ok := h.loadOwnedSeries(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	payload, err := json.Marshal(message)
+
+	_, ent, err := plans.Load(h.DB, series.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load plan"})
+		return
+	}
+
+	applySchedule(series, req.ScheduleRequest)
+	if req.PostsPerDay != 0 {
+		if err := plans.CheckPostsPerDay(ent, req.PostsPerDay); err != nil {
+			respondQuotaError(c, err)
+			return
+		}
+		series.PostsPerDay = req.PostsPerDay
+	}
+	specs, err := scheduler.Specs(*series)
 	if err != nil {
-		log.Printf("Error marshalling json: %v", err)
-	} else {
-		err := h.Redis.Publish(c.Request.Context(), seriesCreatedChannel, payload).Err()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !checkSchedulePosts(c, ent, specs) {
+		return
+	}
+
+	if err := h.DB.Save(series).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	h.publish(c, events.SeriesUpdated, series)
+	c.JSON(http.StatusOK, series)
+}
+
+// UpdateSeries edits a series. Setting is_active to false pauses it: the
+// scheduler drops its jobs and videos that haven't started processing yet are
+// cancelled. Setting it back to true resumes the schedule.
+func (h *Handler) UpdateSeries(c *gin.Context) {
+	series, ok := h.loadOwnedSeries(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateSeriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Title != nil {
+		if *req.Title == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Title cannot be empty"})
+			return
+		}
+		series.Title = *req.Title
+	}
+	if req.Description != nil {
+		series.Description = *req.Description
+	}
+	if req.Style != nil {
+		applyStyle(series, *req.Style)
+	}
+	pausing := req.IsActive != nil && !*req.IsActive && series.IsActive
+	resuming := req.IsActive != nil && *req.IsActive && !series.IsActive
+	if resuming {
+		// A resumed series counts against the plan again.
+		_, ent, err := plans.Load(h.DB, series.UserID)
 		if err != nil {
-			log.Printf("Error publishing to %s: %v", seriesCreatedChannel, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load plan"})
+			return
+		}
+		if err := plans.CheckNewSeries(h.DB, series.UserID, ent, series.PostsPerDay); err != nil {
+			respondQuotaError(c, err)
+			return
+		}
+	}
+	if req.IsActive != nil {
+		series.IsActive = *req.IsActive
+	}
+
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(series).Error; err != nil {
+			return err
+		}
+		if pausing {
+			return cancelInFlightVideos(tx, series.ID)
 		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update series"})
+		return
 	}
 
+	switch {
+	case pausing:
+		h.publish(c, events.SeriesPaused, series)
+	case resuming:
+		h.publish(c, events.SeriesResumed, series)
+	default:
+		h.publish(c, events.SeriesUpdated, series)
+	}
 	c.JSON(http.StatusOK, series)
 }
 
+// DeleteSeries deletes a series and stops generating its videos.
+func (h *Handler) DeleteSeries(c *gin.Context) {
+	series, ok := h.loadOwnedSeries(c)
+	if !ok {
+		return
+	}
+
+	// The series is soft-deleted and its videos are kept, so they still count
+	// towards this month's quota. Workers drop tasks for deleted series.
+	if err := h.DB.Delete(series).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete series"})
+		return
+	}
+
+	h.publish(c, events.SeriesDeleted, series)
+	c.JSON(http.StatusOK, gin.H{"message": "Series deleted"})
+}
+
+// cancelInFlightVideos marks videos that are queued or mid-generation as
+// cancelled. Workers drop tasks for cancelled videos before their next step.
+func cancelInFlightVideos(tx *gorm.DB, seriesID uint) error {
+	return tx.Model(&models.Video{}).
+		Where("series_id = ? AND status IN ?", seriesID, models.InFlightVideoStatuses).
+		Update("status", "cancelled").Error
+}
+
+// respondQuotaError writes a 403 for plan limit violations and a 500 for
+// anything else.
+func respondQuotaError(c *gin.Context, err error) {
+	if errors.Is(err, plans.ErrQuotaExceeded) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check plan limits"})
+}
+
+// loadOwnedSeries loads the series in the :id path parameter if it belongs to
+// the current user, writing an error response and returning false otherwise.
+func (h *Handler) loadOwnedSeries(c *gin.Context) (*models.Series, bool) {
+	seriesID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid series ID"})
+		return nil, false
+	}
+
+	userID := c.GetUint("user_id")
+
+	var series models.Series
+	if err := h.DB.First(&series, "id = ? AND user_id = ?", seriesID, userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return nil, false
+	}
+	return &series, true
+}
+
+// publish records a series lifecycle event. Failures are only logged: the
+// scheduler's periodic reconcile still picks the change up from the database.
+func (h *Handler) publish(c *gin.Context, eventType string, series *models.Series) {
+	evt := events.Event{
+		Type:     eventType,
+		SeriesID: series.ID,
+		UserID:   series.UserID,
+	}
+	if _, err := h.Events.Publish(c.Request.Context(), events.SeriesStream, evt); err != nil {
+		log.Printf("Error publishing %s event for series %d: %v", eventType, series.ID, err)
+	}
+}
+
 // ... (GetUserSeries and GetSeriesVideos remain unchanged) ...
 func (h *Handler) GetUserSeries(c *gin.Context) {
 	userID := c.GetUint("user_id")
@@ -0,0 +1,77 @@
+package series
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// newTestHandler returns a handler backed by an in-memory database and Redis.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.User{}, &models.Series{}, &models.Video{}); err != nil {
+		t.Fatal(err)
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return NewHandler(db, rdb)
+}
+
+func TestDeleteSeriesKeepsQuotaUsage(t *testing.T) {
+	h := newTestHandler(t)
+	user := models.User{GoogleID: "g1", Email: "a@example.com"}
+	h.DB.Create(&user)
+	series := models.Series{UserID: user.ID, Title: "Space", IsActive: true}
+	h.DB.Create(&series)
+	now := time.Now().UTC()
+	for _, status := range []string{"completed", "pending_script"} {
+		h.DB.Create(&models.Video{SeriesID: series.ID, Status: status, CreatedAt: now})
+	}
+
+	before, err := plans.VideosThisMonth(h.DB, user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodDelete, "/series/"+strconv.Itoa(int(series.ID)), nil)
+	c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(series.ID))}}
+	c.Set("user_id", user.ID)
+	h.DeleteSeries(c)
+	if w.Code != http.StatusOK {
+		t.Fatalf("DeleteSeries returned %d: %s", w.Code, w.Body)
+	}
+
+	if err := h.DB.First(&models.Series{}, series.ID).Error; err != gorm.ErrRecordNotFound {
+		t.Errorf("deleted series still found: %v", err)
+	}
+	after, err := plans.VideosThisMonth(h.DB, user.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != 2 || after != before {
+		t.Errorf("VideosThisMonth = %d before deleting the series and %d after, want 2 both times", before, after)
+	}
+
+	// Re-creating the series doesn't reset the count either
+	h.DB.Create(&models.Series{UserID: user.ID, Title: "Space", IsActive: true})
+	if again, _ := plans.VideosThisMonth(h.DB, user.ID, now); again != before {
+		t.Errorf("VideosThisMonth = %d after re-creating the series, want %d", again, before)
+	}
+}
@@ -3,13 +3,21 @@ package stripe
 import (
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/drewmudry/instashorts-api/referrals"
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v76"
 	"github.com/stripe/stripe-go/v76/account"
 	"github.com/stripe/stripe-go/v76/accountlink"
+	portalsession "github.com/stripe/stripe-go/v76/billingportal/session"
+	"github.com/stripe/stripe-go/v76/checkout/session"
+	"github.com/stripe/stripe-go/v76/customer"
 	"gorm.io/gorm"
 )
 
@@ -110,18 +118,192 @@ func (h *Handler) GetConnectAccountStatus(c *gin.Context) {
 		return
 	}
 
-	// Retrieve account from Stripe to check status
-	acct, err := account.GetByID(*user.StripeConnectAccountID, nil)
+	// State is kept current by account.updated webhooks; fetch it only if
+	// none has arrived yet
+	if user.ConnectSyncedAt == nil {
+		if err := referrals.RefreshConnectAccount(h.DB, &user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account status"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"connected":            true,
+		"onboarding_complete":  user.ConnectChargesEnabled && user.ConnectPayoutsEnabled,
+		"charges_enabled":      user.ConnectChargesEnabled,
+		"payouts_enabled":      user.ConnectPayoutsEnabled,
+		"details_submitted":    user.ConnectDetailsSubmitted,
+		"requirements":         user.ConnectRequirements,
+		"referral_code_active": user.ReferralCodeActive,
+		"synced_at":            user.ConnectSyncedAt,
+	})
+}
+
+type CreateCheckoutSessionRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// CreateCheckoutSession starts a Stripe Checkout subscription for a paid plan,
+// creating the user's Stripe customer first if they don't have one yet.
+func (h *Handler) CreateCheckoutSession(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req CreateCheckoutSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	priceID := plans.PriceID(req.Plan)
+	if priceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown plan"})
+		return
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Plan changes for existing subscribers go through the billing portal, so
+	// they aren't charged for two subscriptions. The ID is only cleared once a
+	// subscription has ended, so this covers past-due and unpaid ones too.
+	if user.StripeSubscriptionID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "You already have a subscription. Use /stripe/billing-portal to change or fix it."})
+		return
+	}
+
+	customerID, err := h.ensureCustomer(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Stripe customer"})
+		return
+	}
+
+	frontendURL := os.Getenv("FRONTEND_URL")
+	userRef := strconv.FormatUint(uint64(user.ID), 10)
+	params := &stripe.CheckoutSessionParams{
+		Mode:              stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		Customer:          stripe.String(customerID),
+		ClientReferenceID: stripe.String(userRef),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(priceID),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
+			Metadata: map[string]string{
+				"user_id": userRef,
+				"plan":    req.Plan,
+			},
+		},
+		SuccessURL: stripe.String(fmt.Sprintf("%s/dashboard/billing/success?session_id={CHECKOUT_SESSION_ID}", frontendURL)),
+		CancelURL:  stripe.String(fmt.Sprintf("%s/dashboard/billing", frontendURL)),
+	}
+
+	s, err := session.New(params)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve account status"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"connected":           true,
-		"onboarding_complete": acct.ChargesEnabled && acct.PayoutsEnabled,
-		"charges_enabled":     acct.ChargesEnabled,
-		"payouts_enabled":     acct.PayoutsEnabled,
-		"details_submitted":   acct.DetailsSubmitted,
+		"checkout_url": s.URL,
+		"session_id":   s.ID,
 	})
 }
+
+// ensureCustomer returns the user's Stripe customer ID, creating and saving
+// a customer if they don't have one.
+func (h *Handler) ensureCustomer(user *models.User) (string, error) {
+	if user.StripeCustomerID != nil && *user.StripeCustomerID != "" {
+		return *user.StripeCustomerID, nil
+	}
+
+	params := &stripe.CustomerParams{
+		Email: stripe.String(user.Email),
+		Name:  stripe.String(user.FullName),
+	}
+	params.AddMetadata("user_id", strconv.FormatUint(uint64(user.ID), 10))
+
+	cust, err := customer.New(params)
+	if err != nil {
+		return "", err
+	}
+
+	user.StripeCustomerID = &cust.ID
+	if err := h.DB.Model(user).Update("stripe_customer_id", cust.ID).Error; err != nil {
+		return "", err
+	}
+	return cust.ID, nil
+}
+
+// defaultPortalReturnPath is where the billing portal sends users back to.
+const defaultPortalReturnPath = "/dashboard/billing"
+
+type CreateBillingPortalSessionRequest struct {
+	// Optional path on the frontend to return to, e.g. "/dashboard/settings".
+	ReturnPath string `json:"return_path"`
+}
+
+// CreateBillingPortalSession opens a Stripe billing portal session where the
+// user can update their card, switch plans or cancel.
+func (h *Handler) CreateBillingPortalSession(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var req CreateBillingPortalSessionRequest
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	returnPath := defaultPortalReturnPath
+	if req.ReturnPath != "" {
+		if !isLocalPath(req.ReturnPath) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "return_path must be a path starting with /"})
+			return
+		}
+		returnPath = req.ReturnPath
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if user.StripeCustomerID == nil || *user.StripeCustomerID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No billing account found. Subscribe to a plan first."})
+		return
+	}
+
+	params := &stripe.BillingPortalSessionParams{
+		Customer:  stripe.String(*user.StripeCustomerID),
+		ReturnURL: stripe.String(strings.TrimSuffix(os.Getenv("FRONTEND_URL"), "/") + returnPath),
+	}
+
+	s, err := portalsession.New(params)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create billing portal session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"portal_url": s.URL,
+	})
+}
+
+// isLocalPath reports whether p is a path on our own frontend rather than a
+// redirect elsewhere. Browsers read a backslash as "/", so "/\evil.com" would
+// otherwise pass as a path but resolve to another host.
+func isLocalPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.Contains(p, "\\") {
+		return false
+	}
+	u, err := url.Parse(p)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
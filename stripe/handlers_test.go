@@ -0,0 +1,25 @@
+package stripe
+
+import "testing"
+
+func TestIsLocalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/dashboard/billing", true},
+		{"/dashboard/settings?tab=plan#top", true},
+		{"/", true},
+		{"dashboard", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"/dashboard\\..\\x", false},
+		{"https://evil.com/path", false},
+		{"/path\nwith-newline", false},
+	}
+	for _, tt := range tests {
+		if got := isLocalPath(tt.path); got != tt.want {
+			t.Errorf("isLocalPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
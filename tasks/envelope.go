@@ -0,0 +1,183 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ---
+// TASK ENVELOPE
+// ---
+// Every task pushed to Redis is wrapped in an Envelope so workers know where it
+// came from and how many times it has been tried. Payloads written before the
+// envelope existed (bare {"video_id": 1} JSON) are still accepted by Decode.
+
+// EnvelopeVersion is the current envelope schema version.
+const EnvelopeVersion = 1
+
+// Envelope carries a task payload together with its delivery metadata.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`    // Queue the task was enqueued on
+	Version       int             `json:"version"` // 0 for legacy bare payloads
+	EnqueuedAt    time.Time       `json:"enqueued_at"`
+	Attempt       int             `json:"attempt"` // 1 on the first delivery
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Deadline      *time.Time      `json:"deadline,omitempty"`
+	FirstFailedAt *time.Time      `json:"first_failed_at,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// NewEnvelope wraps payload for the given queue. The correlation ID is taken
+// from ctx so tasks chained from a handler or request share one trace.
+func NewEnvelope(ctx context.Context, taskType string, payload interface{}) (*Envelope, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	id := newID()
+	correlationID := CorrelationIDFromContext(ctx)
+	if correlationID == "" {
+		correlationID = id
+	}
+
+	return &Envelope{
+		ID:            id,
+		Type:          taskType,
+		Version:       EnvelopeVersion,
+		EnqueuedAt:    time.Now().UTC(),
+		Attempt:       1,
+		CorrelationID: correlationID,
+		Payload:       raw,
+	}, nil
+}
+
+// Encode serialises the envelope for Redis.
+func (e *Envelope) Encode() (string, error) {
+	return Marshal(e)
+}
+
+// Expired reports whether the envelope's deadline has passed.
+func (e *Envelope) Expired(now time.Time) bool {
+	return e.Deadline != nil && now.After(*e.Deadline)
+}
+
+// Decode parses a task read from Redis. Legacy bare payloads are wrapped in a
+// version 0 envelope with a generated ID so callers can treat both the same.
+func Decode(raw string, taskType string) (*Envelope, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(raw), &fields); err != nil {
+		return nil, fmt.Errorf("invalid task payload: %w", err)
+	}
+
+	_, hasPayload := fields["payload"]
+	_, hasVersion := fields["version"]
+	if !hasPayload || !hasVersion {
+		return &Envelope{
+			ID:         newID(),
+			Type:       taskType,
+			Version:    0,
+			EnqueuedAt: time.Now().UTC(),
+			Attempt:    1,
+			Payload:    json.RawMessage(raw),
+		}, nil
+	}
+
+	var env Envelope
+	if err := json.Unmarshal([]byte(raw), &env); err != nil {
+		return nil, fmt.Errorf("invalid task envelope: %w", err)
+	}
+	if env.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported task envelope version %d", env.Version)
+	}
+	if env.Type == "" {
+		env.Type = taskType
+	}
+	if env.Attempt < 1 {
+		env.Attempt = 1
+	}
+	return &env, nil
+}
+
+// EnqueueOption customises the envelope of a task being enqueued.
+type EnqueueOption func(*Envelope)
+
+// WithDeadline drops the task if no worker has started it by t. The deadline
+// also bounds how long its handler may run.
+func WithDeadline(t time.Time) EnqueueOption {
+	return func(e *Envelope) {
+		deadline := t.UTC()
+		e.Deadline = &deadline
+	}
+}
+
+// Enqueue wraps payload in an envelope and pushes it onto a queue.
+func Enqueue(ctx context.Context, rdb *redis.Client, queueName string, payload interface{}, opts ...EnqueueOption) error {
+	env, err := NewEnvelope(ctx, queueName, payload)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(env)
+	}
+	encoded, err := env.Encode()
+	if err != nil {
+		return err
+	}
+	return rdb.LPush(ctx, queueName, encoded).Err()
+}
+
+// ---
+// CONTEXT HELPERS
+// ---
+
+type contextKey int
+
+const (
+	envelopeKey contextKey = iota
+	correlationIDKey
+)
+
+// WithEnvelope attaches the envelope of the task being processed to ctx.
+func WithEnvelope(ctx context.Context, env *Envelope) context.Context {
+	return context.WithValue(ctx, envelopeKey, env)
+}
+
+// EnvelopeFromContext returns the envelope of the task being processed, if any.
+func EnvelopeFromContext(ctx context.Context) (*Envelope, bool) {
+	env, ok := ctx.Value(envelopeKey).(*Envelope)
+	return env, ok
+}
+
+// WithCorrelationID sets the correlation ID used for tasks enqueued with ctx.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID set on ctx, falling back
+// to that of the task currently being processed.
+func CorrelationIDFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(correlationIDKey).(string); ok && id != "" {
+		return id
+	}
+	if env, ok := EnvelopeFromContext(ctx); ok {
+		return env.CorrelationID
+	}
+	return ""
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
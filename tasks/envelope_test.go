@@ -0,0 +1,126 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestDecode(t *testing.T) {
+	t.Run("legacy payload", func(t *testing.T) {
+		env, err := Decode(`{"video_id": 1}`, QueueVideoTitle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.Version != 0 || env.Attempt != 1 || env.Type != QueueVideoTitle || env.ID == "" {
+			t.Errorf("legacy envelope = %+v", env)
+		}
+		if string(env.Payload) != `{"video_id": 1}` {
+			t.Errorf("payload = %s, want the raw task", env.Payload)
+		}
+	})
+
+	t.Run("envelope", func(t *testing.T) {
+		raw := `{"id":"abc","type":"","version":1,"attempt":0,"correlation_id":"req-1","payload":{"video_id":2}}`
+		env, err := Decode(raw, QueueVideoScript)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.ID != "abc" || env.CorrelationID != "req-1" || string(env.Payload) != `{"video_id":2}` {
+			t.Errorf("envelope = %+v", env)
+		}
+		// Missing fields fall back to the queue and a first attempt
+		if env.Type != QueueVideoScript || env.Attempt != 1 {
+			t.Errorf("type %q attempt %d, want %q attempt 1", env.Type, env.Attempt, QueueVideoScript)
+		}
+	})
+
+	t.Run("newer version", func(t *testing.T) {
+		if _, err := Decode(`{"version":2,"payload":{}}`, QueueVideoTitle); err == nil {
+			t.Error("decoded an envelope from a newer version")
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		if _, err := Decode(`not json`, QueueVideoTitle); err == nil {
+			t.Error("decoded malformed JSON")
+		}
+	})
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Second), now.Add(time.Second)
+	tests := []struct {
+		name     string
+		deadline *time.Time
+		want     bool
+	}{
+		{"no deadline", nil, false},
+		{"before deadline", &future, false},
+		{"at deadline", &now, false},
+		{"after deadline", &past, true},
+	}
+	for _, tt := range tests {
+		env := Envelope{Deadline: tt.deadline}
+		if got := env.Expired(now); got != tt.want {
+			t.Errorf("%s: Expired = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	deadline := time.Date(2026, 3, 1, 9, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	ctx := WithCorrelationID(context.Background(), "req-42")
+	if err := Enqueue(ctx, rdb, QueueVideoTitle, TitleTaskPayload{VideoID: 9}, WithDeadline(deadline)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := rdb.RPop(context.Background(), QueueVideoTitle).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := Decode(raw, QueueVideoTitle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != EnvelopeVersion || env.Attempt != 1 || env.Type != QueueVideoTitle {
+		t.Errorf("envelope = %+v", env)
+	}
+	if env.CorrelationID != "req-42" {
+		t.Errorf("correlation ID = %q, want the request's", env.CorrelationID)
+	}
+	if env.Deadline == nil || !env.Deadline.Equal(deadline) || env.Deadline.Location() != time.UTC {
+		t.Errorf("deadline = %v, want %v in UTC", env.Deadline, deadline)
+	}
+	var payload TitleTaskPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil || payload.VideoID != 9 {
+		t.Errorf("payload = %s", env.Payload)
+	}
+}
+
+func TestCorrelationIDFromContext(t *testing.T) {
+	env := &Envelope{CorrelationID: "from-task"}
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"none", context.Background(), ""},
+		{"current task", WithEnvelope(context.Background(), env), "from-task"},
+		{"explicit", WithCorrelationID(WithEnvelope(context.Background(), env), "explicit"), "explicit"},
+	}
+	for _, tt := range tests {
+		if got := CorrelationIDFromContext(tt.ctx); got != tt.want {
+			t.Errorf("%s: correlation ID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package usage
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultSummaryDays = 30
+	maxSummaryDays     = 365
+)
+
+type Handler struct {
+	DB *gorm.DB
+}
+
+func NewHandler(db *gorm.DB) *Handler {
+	return &Handler{DB: db}
+}
+
+// Totals are aggregated LLM usage. Costs are in USD millionths.
+type Totals struct {
+	Calls            int64 `json:"calls"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	CostMicros       int64 `json:"cost_micros"`
+}
+
+// SeriesUsage is one series' share of the spend. Title is empty for series
+// that have since been deleted.
+type SeriesUsage struct {
+	SeriesID uint   `json:"series_id"`
+	Title    string `json:"title"`
+	Totals
+}
+
+// DailyUsage is the spend for one UTC day.
+type DailyUsage struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	Totals
+}
+
+// Summary is the response of GetUsageSummary.
+type Summary struct {
+	From   time.Time     `json:"from"`
+	To     time.Time     `json:"to"`
+	Total  Totals        `json:"total"`
+	Series []SeriesUsage `json:"series"`
+	Daily  []DailyUsage  `json:"daily"`
+}
+
+const totalsSelect = "COUNT(*) AS calls, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(cost_micros), 0) AS cost_micros"
+
+// GetUsageSummary returns the current user's LLM spend over the last ?days=
+// days (default 30), broken down per series and per day.
+func (h *Handler) GetUsageSummary(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	days := defaultSummaryDays
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSummaryDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+			return
+		}
+		days = n
+	}
+
+	now := time.Now().UTC()
+	from := now.Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	summary := Summary{From: from, To: now, Series: []SeriesUsage{}, Daily: []DailyUsage{}}
+
+	scope := func() *gorm.DB {
+		return h.DB.Model(&models.LLMUsage{}).
+			Where("llm_usage.user_id = ? AND llm_usage.created_at >= ?", userID, from)
+	}
+
+	if err := scope().Select(totalsSelect).Scan(&summary.Total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	err := scope().
+		Select("llm_usage.series_id, COALESCE(series.title, '') AS title, " + totalsSelect).
+		Joins("LEFT JOIN series ON series.id = llm_usage.series_id").
+		Where("llm_usage.series_id IS NOT NULL").
+		Group("llm_usage.series_id, series.title").
+		Order("cost_micros DESC").
+		Scan(&summary.Series).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	err = scope().
+		Select("TO_CHAR(llm_usage.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS date, " + totalsSelect).
+		Group("date").
+		Order("date").
+		Scan(&summary.Daily).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// GetQuota returns the current user's plan limits and how much of them is used.
+func (h *Handler) GetQuota(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	quota, err := plans.GetQuota(h.DB, userID, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load quota"})
+		return
+	}
+	c.JSON(http.StatusOK, quota)
+}
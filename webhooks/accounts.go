@@ -0,0 +1,31 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/referrals"
+	"github.com/stripe/stripe-go/v76"
+)
+
+// handleAccountUpdated caches a referrer's Connect account state, which also
+// turns their referral code off while payouts are disabled.
+func (h *Handler) handleAccountUpdated(event stripe.Event) error {
+	var acct stripe.Account
+	if err := json.Unmarshal(event.Data.Raw, &acct); err != nil {
+		return fmt.Errorf("error parsing account: %w", err)
+	}
+
+	user, err := referrals.SyncConnectAccount(h.DB, &acct, time.Unix(event.Created, 0).UTC())
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		fmt.Printf("No user to update for account %s (unknown account or stale event)\n", acct.ID)
+		return nil
+	}
+
+	fmt.Printf("Synced account %s for user %d (payouts enabled: %t)\n", acct.ID, user.ID, acct.PayoutsEnabled)
+	return nil
+}
@@ -0,0 +1,230 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/referrals"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/charge"
+	"github.com/stripe/stripe-go/v76/transfer"
+	"github.com/stripe/stripe-go/v76/transferreversal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// handleChargeRefunded claws back the share of each commission paid on the
+// charge that matches the share of the charge refunded so far.
+func (h *Handler) handleChargeRefunded(event stripe.Event) error {
+	var ch stripe.Charge
+	if err := json.Unmarshal(event.Data.Raw, &ch); err != nil {
+		return fmt.Errorf("error parsing charge: %w", err)
+	}
+
+	return h.adjustCommissions(ch.ID, "refund", false, func(c models.ReferralCommission) int64 {
+		return refundShare(c, &ch)
+	})
+}
+
+// handleDisputeCreated claws back the whole commission paid on a disputed
+// charge; the disputed funds are withheld from us until the dispute closes.
+func (h *Handler) handleDisputeCreated(event stripe.Event) error {
+	var dispute stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+		return fmt.Errorf("error parsing dispute: %w", err)
+	}
+	if dispute.Charge == nil {
+		return nil
+	}
+
+	return h.adjustCommissions(dispute.Charge.ID, "dispute", false, func(c models.ReferralCommission) int64 {
+		return c.AmountCents
+	})
+}
+
+// handleDisputeClosed gives back the commissions clawed back for a dispute
+// that we won or that never became one, less whatever has been refunded. A
+// lost dispute leaves them reversed.
+func (h *Handler) handleDisputeClosed(event stripe.Event) error {
+	var dispute stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &dispute); err != nil {
+		return fmt.Errorf("error parsing dispute: %w", err)
+	}
+	if dispute.Charge == nil {
+		return nil
+	}
+	if dispute.Status != stripe.DisputeStatusWon && dispute.Status != stripe.DisputeStatusWarningClosed {
+		fmt.Printf("Dispute %s closed as %s, commissions on charge %s stay reversed\n", dispute.ID, dispute.Status, dispute.Charge.ID)
+		return nil
+	}
+
+	// The event only carries the charge ID; its refunds decide what stays reversed
+	ch, err := charge.Get(dispute.Charge.ID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch charge %s: %w", dispute.Charge.ID, err)
+	}
+	return h.adjustCommissions(ch.ID, "dispute closed", true, func(c models.ReferralCommission) int64 {
+		return refundShare(c, ch)
+	})
+}
+
+// refundShare is the part of a commission matching the share of its charge
+// refunded so far.
+func refundShare(c models.ReferralCommission, ch *stripe.Charge) int64 {
+	if ch.Amount <= 0 {
+		return 0
+	}
+	return c.AmountCents * ch.AmountRefunded / ch.Amount
+}
+
+// reversalDelta is how much more of a commission to reverse for target to
+// have been reversed in total. target is capped at the commission; a negative
+// delta is an amount to give back.
+func reversalDelta(c models.ReferralCommission, target int64) int64 {
+	if target > c.AmountCents {
+		target = c.AmountCents
+	}
+	if target < 0 {
+		target = 0
+	}
+	return target - c.ReversedCents
+}
+
+// adjustCommissions brings each commission on a charge to the total reversal
+// returned by target. Only the difference is reversed, so repeated or partial
+// refunds add up correctly. Reversals are only undone when restore is set,
+// so an older refund event arriving late can't give money back.
+func (h *Handler) adjustCommissions(chargeID, reason string, restore bool, target func(models.ReferralCommission) int64) error {
+	if chargeID == "" {
+		return nil
+	}
+
+	var ids []uint
+	if err := h.DB.Model(&models.ReferralCommission{}).Where("charge_id = ?", chargeID).Order("id").Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("failed to load commissions for charge %s: %w", chargeID, err)
+	}
+	if len(ids) == 0 {
+		fmt.Printf("No referral commissions to adjust for charge %s\n", chargeID)
+		return nil
+	}
+
+	for _, id := range ids {
+		if err := h.adjustCommission(id, reason, restore, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adjustCommission reverses or restores one commission. The row stays locked
+// from reading the current total until the new one is saved, so concurrent
+// events and payouts can't act on a stale total. Pending commissions haven't
+// been paid, so only their totals change.
+func (h *Handler) adjustCommission(id uint, reason string, restore bool, target func(models.ReferralCommission) int64) error {
+	var commission models.ReferralCommission
+	var delta int64
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&commission, id).Error; err != nil {
+			return err
+		}
+		delta = reversalDelta(commission, target(commission))
+		if delta == 0 || (delta < 0 && !restore) {
+			delta = 0
+			return nil
+		}
+		reversedCents := commission.ReversedCents + delta
+		updates := map[string]interface{}{
+			"reversed_cents": reversedCents,
+			"status":         referrals.CommissionStatus(commission, reversedCents),
+		}
+
+		paid := commission.TransferID != ""
+		switch {
+		case paid && delta > 0:
+			if err := reverseTransfer(commission, delta, reversedCents, reason); err != nil {
+				return err
+			}
+		case paid:
+			t, err := restoreTransfer(tx, commission, -delta, reason)
+			if err != nil {
+				return err
+			}
+			// Later reversals come out of the new transfer
+			updates["transfer_id"] = t.ID
+		case commission.Status == models.CommissionReversed:
+			// A fully reversed commission was left out of its payout, so it
+			// goes into the next one
+			updates["payout_id"] = nil
+		}
+
+		if err := tx.Model(&commission).Updates(updates).Error; err != nil {
+			return err
+		}
+		if !paid {
+			return nil
+		}
+		return tx.Model(&models.User{}).
+			Where("id = ?", commission.ReferrerUserID).
+			Update("referral_earnings_cents", gorm.Expr("referral_earnings_cents - ?", delta)).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to adjust commission %d after %s: %w", id, reason, err)
+	}
+
+	if delta > 0 {
+		fmt.Printf("Reversed $%.2f of commission %d after %s\n", float64(delta)/100, id, reason)
+	} else if delta < 0 {
+		fmt.Printf("Restored $%.2f of commission %d after %s\n", float64(-delta)/100, id, reason)
+	}
+	return nil
+}
+
+// reverseTransfer claws amount back from a paid commission's transfer.
+func reverseTransfer(commission models.ReferralCommission, amount, reversedCents int64, reason string) error {
+	params := &stripe.TransferReversalParams{
+		ID:     stripe.String(commission.TransferID),
+		Amount: stripe.Int64(amount),
+		Metadata: map[string]string{
+			"commission_id": fmt.Sprintf("%d", commission.ID),
+			"reason":        reason,
+		},
+	}
+	// Keyed on the transfer and running total so a retried event reverses
+	// nothing extra
+	params.SetIdempotencyKey(fmt.Sprintf("referral-reversal-%d-%s-%d", commission.ID, commission.TransferID, reversedCents))
+	if _, err := transferreversal.New(params); err != nil {
+		return fmt.Errorf("failed to reverse transfer %s: %w", commission.TransferID, err)
+	}
+	return nil
+}
+
+// restoreTransfer pays amount of a reversed commission to the referrer again.
+func restoreTransfer(tx *gorm.DB, commission models.ReferralCommission, amount int64, reason string) (*stripe.Transfer, error) {
+	var referrer models.User
+	if err := tx.First(&referrer, commission.ReferrerUserID).Error; err != nil {
+		return nil, err
+	}
+	if !referrer.CanEarnReferrals() {
+		return nil, fmt.Errorf("referrer %d has no Stripe Connect account", referrer.ID)
+	}
+
+	params := &stripe.TransferParams{
+		Amount:      stripe.Int64(amount),
+		Currency:    stripe.String(commission.Currency),
+		Destination: stripe.String(*referrer.StripeConnectAccountID),
+		Description: stripe.String("Restored referral commission"),
+		Metadata: map[string]string{
+			"referrer_user_id": fmt.Sprintf("%d", referrer.ID),
+			"commission_id":    fmt.Sprintf("%d", commission.ID),
+			"reason":           reason,
+		},
+	}
+	// The transfer being replaced makes the key unique to this restoration
+	params.SetIdempotencyKey(fmt.Sprintf("referral-restore-%d-%s", commission.ID, commission.TransferID))
+	t, err := transfer.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore commission to referrer %d: %w", referrer.ID, err)
+	}
+	return t, nil
+}
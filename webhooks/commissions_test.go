@@ -0,0 +1,216 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/stripe/stripe-go/v76"
+)
+
+func TestRefundShare(t *testing.T) {
+	tests := []struct {
+		name       string
+		commission int64
+		amount     int64
+		refunded   int64
+		want       int64
+	}{
+		{"no refund", 580, 2900, 0, 0},
+		{"full refund", 580, 2900, 2900, 580},
+		{"half refund", 580, 2900, 1450, 290},
+		{"rounds down", 580, 2900, 1000, 200},
+		{"zero amount charge", 580, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := models.ReferralCommission{AmountCents: tt.commission}
+			ch := &stripe.Charge{Amount: tt.amount, AmountRefunded: tt.refunded}
+			if got := refundShare(c, ch); got != tt.want {
+				t.Errorf("refundShare = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReversalDelta(t *testing.T) {
+	tests := []struct {
+		name     string
+		reversed int64
+		target   int64
+		want     int64
+	}{
+		{"first reversal", 0, 290, 290},
+		{"further refund", 290, 580, 290},
+		{"repeated event", 290, 290, 0},
+		{"older event", 290, 100, -190},
+		{"capped at commission", 0, 1000, 580},
+		{"already fully reversed", 580, 1000, 0},
+		{"restore after dispute", 580, 0, -580},
+		{"negative target", 100, -50, -100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := models.ReferralCommission{AmountCents: 580, ReversedCents: tt.reversed}
+			if got := reversalDelta(c, tt.target); got != tt.want {
+				t.Errorf("reversalDelta(reversed %d, target %d) = %d, want %d", tt.reversed, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+// createReferrer adds a referrer with a Connect account and earnings.
+func createReferrer(t *testing.T, h *Handler, earnings int64) models.User {
+	t.Helper()
+	account := "acct_1"
+	referrer := models.User{GoogleID: "g1", Email: "referrer@example.com", StripeConnectAccountID: &account, ReferralEarningsCents: earnings}
+	if err := h.DB.Create(&referrer).Error; err != nil {
+		t.Fatal(err)
+	}
+	return referrer
+}
+
+// createCommission adds a 580 cent commission on charge ch_1, paid through
+// transfer tr_1 unless transferID is empty.
+func createCommission(t *testing.T, h *Handler, referrerID uint, transferID string) models.ReferralCommission {
+	t.Helper()
+	status := models.CommissionPaid
+	if transferID == "" {
+		status = models.CommissionPending
+	}
+	c := models.ReferralCommission{
+		ReferrerUserID: referrerID,
+		ReferredUserID: 99,
+		InvoiceID:      "in_1",
+		ChargeID:       "ch_1",
+		TransferID:     transferID,
+		AmountCents:    580,
+		Currency:       "usd",
+		RateBps:        2000,
+		Status:         status,
+	}
+	if err := h.DB.Create(&c).Error; err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func refundEvent(t *testing.T, refunded int64) stripe.Event {
+	return newEvent(t, "charge.refunded", map[string]interface{}{"id": "ch_1", "amount": 2900, "amount_refunded": refunded})
+}
+
+func TestChargeRefundedReversesOnlyTheDifference(t *testing.T) {
+	h := newTestHandler(t)
+	fake := useFakeStripe(t)
+	referrer := createReferrer(t, h, 580)
+	commission := createCommission(t, h, referrer.ID, "tr_1")
+	reversals := "/v1/transfers/tr_1/reversals"
+
+	// Half refunded, the same event again, then the rest
+	for _, refunded := range []int64{1450, 1450, 2900} {
+		if err := h.handleChargeRefunded(refundEvent(t, refunded)); err != nil {
+			t.Fatalf("handleChargeRefunded(%d): %v", refunded, err)
+		}
+	}
+
+	calls := fake.posts(reversals)
+	if len(calls) != 2 {
+		t.Fatalf("made %d transfer reversals, want 2", len(calls))
+	}
+	for i, call := range calls {
+		params := call.Params.(*stripe.TransferReversalParams)
+		if *params.Amount != 290 {
+			t.Errorf("reversal %d of %d cents, want 290", i+1, *params.Amount)
+		}
+	}
+
+	h.DB.First(&commission, commission.ID)
+	if commission.ReversedCents != 580 || commission.Status != models.CommissionReversed {
+		t.Errorf("commission reversed %d cents with status %s, want 580 and %s", commission.ReversedCents, commission.Status, models.CommissionReversed)
+	}
+	h.DB.First(&referrer, referrer.ID)
+	if referrer.ReferralEarningsCents != 0 {
+		t.Errorf("referrer earnings = %d, want 0", referrer.ReferralEarningsCents)
+	}
+}
+
+func TestChargeRefundedOnPendingCommissionOnlyChangesTotals(t *testing.T) {
+	h := newTestHandler(t)
+	fake := useFakeStripe(t)
+	referrer := createReferrer(t, h, 0)
+	commission := createCommission(t, h, referrer.ID, "")
+
+	if err := h.handleChargeRefunded(refundEvent(t, 1450)); err != nil {
+		t.Fatalf("handleChargeRefunded: %v", err)
+	}
+
+	if len(fake.calls) != 0 {
+		t.Errorf("made Stripe calls %v for an unpaid commission", fake.calls)
+	}
+	h.DB.First(&commission, commission.ID)
+	if commission.ReversedCents != 290 || commission.Status != models.CommissionPending {
+		t.Errorf("commission reversed %d cents with status %s, want 290 and still pending", commission.ReversedCents, commission.Status)
+	}
+	h.DB.First(&referrer, referrer.ID)
+	if referrer.ReferralEarningsCents != 0 {
+		t.Errorf("referrer earnings = %d, want unchanged", referrer.ReferralEarningsCents)
+	}
+}
+
+func TestLateRefundEventDoesNotRestore(t *testing.T) {
+	h := newTestHandler(t)
+	fake := useFakeStripe(t)
+	referrer := createReferrer(t, h, 580)
+	commission := createCommission(t, h, referrer.ID, "tr_1")
+
+	if err := h.handleChargeRefunded(refundEvent(t, 2900)); err != nil {
+		t.Fatal(err)
+	}
+	// An older event with a smaller refund arrives after the full one
+	if err := h.handleChargeRefunded(refundEvent(t, 1450)); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := fake.posts("/v1/transfers"); len(calls) != 0 {
+		t.Errorf("late refund event restored the commission: %v", calls)
+	}
+	h.DB.First(&commission, commission.ID)
+	if commission.ReversedCents != 580 {
+		t.Errorf("commission reversed %d cents, want 580", commission.ReversedCents)
+	}
+}
+
+func TestDisputeWonRestoresUnrefundedCommission(t *testing.T) {
+	h := newTestHandler(t)
+	fake := useFakeStripe(t)
+	referrer := createReferrer(t, h, 580)
+	commission := createCommission(t, h, referrer.ID, "tr_1")
+	dispute := map[string]interface{}{"id": "dp_1", "charge": "ch_1", "status": "won"}
+
+	if err := h.handleDisputeCreated(newEvent(t, "charge.dispute.created", dispute)); err != nil {
+		t.Fatalf("handleDisputeCreated: %v", err)
+	}
+	h.DB.First(&commission, commission.ID)
+	if commission.Status != models.CommissionReversed {
+		t.Fatalf("disputed commission status = %s, want %s", commission.Status, models.CommissionReversed)
+	}
+
+	// A quarter of the charge was refunded while the dispute was open
+	fake.responses["/v1/charges/ch_1"] = `{"id":"ch_1","amount":2900,"amount_refunded":725}`
+	fake.responses["/v1/transfers"] = `{"id":"tr_2"}`
+	if err := h.handleDisputeClosed(newEvent(t, "charge.dispute.closed", dispute)); err != nil {
+		t.Fatalf("handleDisputeClosed: %v", err)
+	}
+
+	transfers := fake.posts("/v1/transfers")
+	if len(transfers) != 1 || *transfers[0].Params.(*stripe.TransferParams).Amount != 435 {
+		t.Fatalf("restoring transfers = %v, want one of 435 cents", transfers)
+	}
+	h.DB.First(&commission, commission.ID)
+	if commission.ReversedCents != 145 || commission.TransferID != "tr_2" || commission.Status != models.CommissionPartiallyReversed {
+		t.Errorf("commission = %+v, want 145 cents reversed on transfer tr_2", commission)
+	}
+	h.DB.First(&referrer, referrer.ID)
+	if referrer.ReferralEarningsCents != 435 {
+		t.Errorf("referrer earnings = %d, want 435", referrer.ReferralEarningsCents)
+	}
+}
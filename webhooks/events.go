@@ -0,0 +1,153 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stripe/stripe-go/v76"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// staleProcessingAfter is how long an event may stay "processing" before we
+// assume the server handling it died and let a retry take it over.
+const staleProcessingAfter = 10 * time.Minute
+
+// claimResult is the outcome of trying to claim an event for processing.
+type claimResult int
+
+const (
+	claimed     claimResult = iota // This request should process the event
+	alreadyDone                    // The event was processed before
+	inProgress                     // Another request is processing it right now
+)
+
+// claimEvent records a verified event and decides whether this delivery
+// should process it. New events, failed events and events stuck processing
+// are claimed; processed events are duplicates.
+func (h *Handler) claimEvent(event stripe.Event, payload []byte) (claimResult, error) {
+	record := models.StripeEvent{
+		ID:       event.ID,
+		Type:     string(event.Type),
+		Status:   models.StripeEventProcessing,
+		Attempts: 1,
+		Payload:  string(payload),
+	}
+	res := h.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return claimed, nil
+	}
+
+	ok, err := h.reclaimEvent(event.ID)
+	if err != nil || ok {
+		return claimed, err
+	}
+
+	var existing models.StripeEvent
+	if err := h.DB.First(&existing, "id = ?", event.ID).Error; err != nil {
+		return 0, err
+	}
+	if existing.Status == models.StripeEventProcessed {
+		return alreadyDone, nil
+	}
+	return inProgress, nil
+}
+
+// reclaimEvent moves a failed or stuck event back to processing, reporting
+// whether it did.
+func (h *Handler) reclaimEvent(id string) (bool, error) {
+	res := h.DB.Model(&models.StripeEvent{}).
+		Where("id = ?", id).
+		Where("status = ? OR (status = ? AND updated_at < ?)",
+			models.StripeEventFailed, models.StripeEventProcessing, time.Now().Add(-staleProcessingAfter)).
+		Updates(map[string]interface{}{
+			"status":   models.StripeEventProcessing,
+			"attempts": gorm.Expr("attempts + 1"),
+		})
+	return res.RowsAffected == 1, res.Error
+}
+
+// process dispatches a claimed event and records the outcome.
+func (h *Handler) process(event stripe.Event) error {
+	procErr := h.dispatch(event)
+
+	updates := map[string]interface{}{}
+	if procErr != nil {
+		fmt.Printf("Error handling %s event %s: %v\n", event.Type, event.ID, procErr)
+		updates["status"] = models.StripeEventFailed
+		updates["error"] = procErr.Error()
+	} else {
+		updates["status"] = models.StripeEventProcessed
+		updates["error"] = ""
+		updates["processed_at"] = time.Now().UTC()
+	}
+	// A failed write only loses the log entry, which is left "processing".
+	// The event itself was handled, and not every handler is safe to run
+	// twice, so Stripe isn't asked to retry it.
+	if err := h.DB.Model(&models.StripeEvent{}).Where("id = ?", event.ID).Updates(updates).Error; err != nil {
+		fmt.Printf("Failed to record outcome of event %s: %v\n", event.ID, err)
+	}
+	return procErr
+}
+
+// ListStripeEvents lists logged webhook events, newest first. Filter with
+// ?status= (default "failed") and cap with ?limit= (default 50).
+func (h *Handler) ListStripeEvents(c *gin.Context) {
+	status := c.DefaultQuery("status", models.StripeEventFailed)
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	var events []models.StripeEvent
+	if err := h.DB.Where("status = ?", status).Order("created_at DESC").Limit(limit).Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load events"})
+		return
+	}
+	c.JSON(http.StatusOK, events)
+}
+
+// ReplayStripeEvent re-processes a failed (or stuck) event from its stored
+// payload.
+func (h *Handler) ReplayStripeEvent(c *gin.Context) {
+	var record models.StripeEvent
+	if err := h.DB.First(&record, "id = ?", c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		}
+		return
+	}
+
+	var event stripe.Event
+	if err := json.Unmarshal([]byte(record.Payload), &event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Stored event payload is invalid"})
+		return
+	}
+
+	ok, err := h.reclaimEvent(record.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Event is %s, only failed events can be replayed", record.Status)})
+		return
+	}
+
+	if err := h.process(event); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replayed": true})
+}
@@ -2,17 +2,20 @@ package webhooks
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/referrals"
 	"github.com/gin-gonic/gin"
 	"github.com/stripe/stripe-go/v76"
-	"github.com/stripe/stripe-go/v76/transfer"
 	"github.com/stripe/stripe-go/v76/webhook"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Handler struct {
@@ -43,116 +46,167 @@ func (h *Handler) HandleStripeWebhook(c *gin.Context) {
 		return
 	}
 
-	// Handle specific event types
+	// Record the event, and skip it if an earlier delivery already handled it
+	result, err := h.claimEvent(event, payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record event"})
+		return
+	}
+	switch result {
+	case alreadyDone:
+		c.JSON(http.StatusOK, gin.H{"received": true, "duplicate": true})
+		return
+	case inProgress:
+		// Non-2xx so Stripe retries in case the other delivery fails
+		c.JSON(http.StatusConflict, gin.H{"error": "Event is already being processed"})
+		return
+	}
+
+	if err := h.process(event); err != nil {
+		// Non-2xx so Stripe retries the delivery
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process event"})
+		return
+	}
+
+	// Return 200 OK to acknowledge receipt
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+// dispatch runs the handler for an event's type. Handlers must be safe to run
+// more than once for the same event, since failed events are retried.
+func (h *Handler) dispatch(event stripe.Event) error {
 	switch event.Type {
 	case "invoice.payment_succeeded":
-		h.handleInvoicePaymentSucceeded(event)
+		return h.handleInvoicePaymentSucceeded(event)
+	case "checkout.session.completed":
+		return h.handleCheckoutSessionCompleted(event)
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		return h.handleSubscriptionChanged(event)
+	case "charge.refunded":
+		return h.handleChargeRefunded(event)
+	case "charge.dispute.created":
+		return h.handleDisputeCreated(event)
+	case "charge.dispute.closed":
+		return h.handleDisputeClosed(event)
 	case "account.updated":
-		// Optional: Handle Connect account updates
-		fmt.Printf("Account updated event received\n")
+		return h.handleAccountUpdated(event)
 	default:
 		fmt.Printf("Unhandled event type: %s\n", event.Type)
 	}
-
-	// Return 200 OK to acknowledge receipt
-	c.JSON(http.StatusOK, gin.H{"received": true})
+	return nil
 }
 
-// handleInvoicePaymentSucceeded processes successful invoice payments and creates referral transfers
-func (h *Handler) handleInvoicePaymentSucceeded(event stripe.Event) {
+// handleInvoicePaymentSucceeded records the referral commission on a paid
+// invoice
+func (h *Handler) handleInvoicePaymentSucceeded(event stripe.Event) error {
 	var invoice stripe.Invoice
 	if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
-		fmt.Printf("Error parsing invoice: %v\n", err)
-		return
+		return fmt.Errorf("error parsing invoice: %w", err)
 	}
 
 	// Get the Stripe customer ID from the invoice
-	customerID := invoice.Customer.ID
-	if customerID == "" {
+	if invoice.Customer == nil || invoice.Customer.ID == "" {
 		fmt.Printf("No customer ID in invoice\n")
-		return
+		return nil
 	}
+	customerID := invoice.Customer.ID
 
 	// Find the user by Stripe customer ID
 	var user models.User
 	if err := h.DB.Where("stripe_customer_id = ?", customerID).First(&user).Error; err != nil {
-		fmt.Printf("User not found for customer ID %s: %v\n", customerID, err)
-		return
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Printf("User not found for customer ID %s\n", customerID)
+			return nil
+		}
+		return fmt.Errorf("failed to load user for customer %s: %w", customerID, err)
+	}
+
+	// Skip invoices whose commission was already paid
+	var paid int64
+	if err := h.DB.Model(&models.ReferralCommission{}).Where("invoice_id = ?", invoice.ID).Count(&paid).Error; err != nil {
+		return fmt.Errorf("failed to check commission for invoice %s: %w", invoice.ID, err)
+	}
+	if paid > 0 {
+		fmt.Printf("Commission for invoice %s already recorded\n", invoice.ID)
+		return nil
 	}
 
 	// Check if user was referred by someone
 	if user.ReferredByUserID == nil {
 		fmt.Printf("User %d was not referred by anyone\n", user.ID)
-		return
+		return nil
+	}
+
+	// A referred user's first payment converts the referral click they
+	// signed up from and starts the commission window, even if it earns no
+	// commission itself
+	paidAt := time.Unix(invoice.Created, 0).UTC()
+	var firstPaidAt time.Time
+	if invoice.AmountPaid > 0 {
+		var err error
+		if firstPaidAt, err = referrals.RecordConversion(h.DB, user.ID, paidAt); err != nil {
+			return fmt.Errorf("failed to record referral conversion of user %d: %w", user.ID, err)
+		}
 	}
 
 	// Find the referrer
 	var referrer models.User
 	if err := h.DB.First(&referrer, *user.ReferredByUserID).Error; err != nil {
-		fmt.Printf("Referrer not found for user %d: %v\n", user.ID, err)
-		return
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			fmt.Printf("Referrer not found for user %d\n", user.ID)
+			return nil
+		}
+		return fmt.Errorf("failed to load referrer of user %d: %w", user.ID, err)
 	}
 
 	// Check if referrer is eligible for payouts
 	if !referrer.CanEarnReferrals() {
 		fmt.Printf("Referrer %d is not eligible for payouts (no Stripe Connect account)\n", referrer.ID)
-		return
+		return nil
+	}
+
+	// Look up the referrer's commission tier
+	tier, err := referrals.ResolveRule(h.DB, referrer.ID)
+	if err != nil {
+		return fmt.Errorf("failed to resolve commission rule for referrer %d: %w", referrer.ID, err)
 	}
+	rule := tier.Rule
 
-	// Calculate commission (20% of the invoice total)
-	const commissionRate = 0.20
-	invoiceTotal := invoice.AmountPaid // Amount in cents
-	commissionAmount := int64(float64(invoiceTotal) * commissionRate)
+	// Only payments within the rule's window after the referred user's first
+	// payment earn commission
+	if !referrals.InWindow(rule, firstPaidAt, paidAt) {
+		fmt.Printf("Invoice %s is outside the %d month commission window for user %d\n", invoice.ID, rule.WindowMonths, user.ID)
+		return nil
+	}
 
+	commissionAmount := referrals.CommissionCents(rule, invoice.AmountPaid)
 	if commissionAmount <= 0 {
 		fmt.Printf("Commission amount is zero or negative\n")
-		return
+		return nil
 	}
 
-	// Get the charge ID to link the transfer to the original payment
+	// Get the charge ID to link the commission to the original payment
 	var chargeID string
 	if invoice.Charge != nil {
 		chargeID = invoice.Charge.ID
 	}
 
-	// Create a transfer to the referrer's Connect account
-	transferParams := &stripe.TransferParams{
-		Amount:      stripe.Int64(commissionAmount),
-		Currency:    stripe.String(string(invoice.Currency)),
-		Destination: stripe.String(*referrer.StripeConnectAccountID),
-		Description: stripe.String(fmt.Sprintf("Referral commission for user %d", user.ID)),
+	// Record the commission as pending; the scheduler's payout job transfers
+	// it. The invoice is unique in the ledger, so a retry only records it once.
+	commission := models.ReferralCommission{
+		ReferrerUserID: referrer.ID,
+		ReferredUserID: user.ID,
+		InvoiceID:      invoice.ID,
+		ChargeID:       chargeID,
+		AmountCents:    commissionAmount,
+		Currency:       string(invoice.Currency),
+		RateBps:        rule.RateBps,
+		Status:         models.CommissionPending,
 	}
-
-	// Link to the original payment if we have a charge ID
-	if chargeID != "" {
-		transferParams.SourceTransaction = stripe.String(chargeID)
-	}
-
-	// Add metadata for tracking
-	transferParams.Metadata = map[string]string{
-		"referrer_user_id": fmt.Sprintf("%d", referrer.ID),
-		"referred_user_id": fmt.Sprintf("%d", user.ID),
-		"invoice_id":       invoice.ID,
-		"commission_rate":  "0.20",
-	}
-
-	// Execute the transfer
-	t, err := transfer.New(transferParams)
-	if err != nil {
-		fmt.Printf("Failed to create transfer: %v\n", err)
-		return
-	}
-
-	fmt.Printf("Successfully created transfer %s for $%.2f to referrer %d\n",
-		t.ID, float64(commissionAmount)/100, referrer.ID)
-
-	// Update referrer's earnings in the database
-	referrer.ReferralEarningsCents += commissionAmount
-	if err := h.DB.Save(&referrer).Error; err != nil {
-		fmt.Printf("Failed to update referrer earnings: %v\n", err)
-		return
+	if err := h.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&commission).Error; err != nil {
+		return fmt.Errorf("failed to record commission for invoice %s: %w", invoice.ID, err)
 	}
 
-	fmt.Printf("Updated referrer %d earnings to $%.2f\n",
-		referrer.ID, float64(referrer.ReferralEarningsCents)/100)
+	fmt.Printf("Recorded pending commission of $%.2f (%d bps) to referrer %d\n", float64(commissionAmount)/100, rule.RateBps, referrer.ID)
+	return nil
 }
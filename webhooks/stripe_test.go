@@ -0,0 +1,153 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/glebarez/sqlite"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/form"
+	"gorm.io/gorm"
+)
+
+// stripeCall is one request made to the fake Stripe API.
+type stripeCall struct {
+	Method string
+	Path   string
+	Params stripe.ParamsContainer
+}
+
+// fakeStripe records API calls and answers each with the JSON registered for
+// its path, or an object with a fresh ID.
+type fakeStripe struct {
+	mu        sync.Mutex
+	calls     []stripeCall
+	responses map[string]string
+}
+
+// useFakeStripe routes Stripe API calls to a fake for the rest of the test.
+func useFakeStripe(t *testing.T) *fakeStripe {
+	t.Helper()
+	f := &fakeStripe{responses: map[string]string{}}
+	previous := stripe.GetBackend(stripe.APIBackend)
+	stripe.SetBackend(stripe.APIBackend, f)
+	t.Cleanup(func() { stripe.SetBackend(stripe.APIBackend, previous) })
+	return f
+}
+
+func (f *fakeStripe) Call(method, path, key string, params stripe.ParamsContainer, v stripe.LastResponseSetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, stripeCall{Method: method, Path: path, Params: params})
+	body, ok := f.responses[path]
+	if !ok {
+		body = fmt.Sprintf(`{"id":"obj_%d"}`, len(f.calls))
+	}
+	return json.Unmarshal([]byte(body), v)
+}
+
+func (f *fakeStripe) CallStreaming(method, path, key string, params stripe.ParamsContainer, v stripe.StreamingLastResponseSetter) error {
+	return fmt.Errorf("fake Stripe: streaming not supported")
+}
+
+func (f *fakeStripe) CallRaw(method, path, key string, body *form.Values, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return fmt.Errorf("fake Stripe: raw calls not supported")
+}
+
+func (f *fakeStripe) CallMultipart(method, path, key, boundary string, body *bytes.Buffer, params *stripe.Params, v stripe.LastResponseSetter) error {
+	return fmt.Errorf("fake Stripe: multipart calls not supported")
+}
+
+func (f *fakeStripe) SetMaxNetworkRetries(int64) {}
+
+// posts returns the POST requests made to path.
+func (f *fakeStripe) posts(path string) []stripeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []stripeCall
+	for _, c := range f.calls {
+		if c.Method == "POST" && c.Path == path {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// newTestHandler returns a handler backed by an in-memory database.
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&models.User{}, &models.ReferralCommission{}, &models.ReferralPayout{},
+		&models.ReferralClick{}, &models.CommissionRule{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(db)
+}
+
+// newEvent wraps object as the data of a Stripe event.
+func newEvent(t *testing.T, eventType string, object interface{}) stripe.Event {
+	t.Helper()
+	raw, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stripe.Event{Type: stripe.EventType(eventType), Data: &stripe.EventData{Raw: raw}}
+}
+
+func invoiceEvent(t *testing.T, id string, paidAt time.Time) stripe.Event {
+	return newEvent(t, "invoice.payment_succeeded", map[string]interface{}{
+		"id":          id,
+		"customer":    "cus_1",
+		"amount_paid": 2900,
+		"currency":    "usd",
+		"created":     paidAt.Unix(),
+		"charge":      "ch_" + id,
+	})
+}
+
+func TestInvoiceWindowStartsAtFirstPayment(t *testing.T) {
+	h := newTestHandler(t)
+	referrer := models.User{GoogleID: "g1", Email: "referrer@example.com"}
+	h.DB.Create(&referrer)
+	customer := "cus_1"
+	user := models.User{GoogleID: "g2", Email: "user@example.com", StripeCustomerID: &customer, ReferredByUserID: &referrer.ID}
+	h.DB.Create(&user)
+	h.DB.Create(&models.CommissionRule{RateBps: 2000, WindowMonths: 12})
+
+	// The first payment earns nothing: the referrer can't be paid yet
+	first := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	if err := h.handleInvoicePaymentSucceeded(invoiceEvent(t, "in_1", first)); err != nil {
+		t.Fatal(err)
+	}
+	account := "acct_1"
+	h.DB.Model(&referrer).Update("stripe_connect_account_id", account)
+
+	tests := []struct {
+		invoiceID string
+		paidAt    time.Time
+		want      bool
+	}{
+		{"in_2", first.AddDate(0, 6, 0), true},
+		// A window counted from the first commission (in_2) would still be open
+		{"in_3", first.AddDate(0, 12, 0), false},
+	}
+	for _, tt := range tests {
+		if err := h.handleInvoicePaymentSucceeded(invoiceEvent(t, tt.invoiceID, tt.paidAt)); err != nil {
+			t.Fatalf("invoice %s: %v", tt.invoiceID, err)
+		}
+		var count int64
+		h.DB.Model(&models.ReferralCommission{}).Where("invoice_id = ?", tt.invoiceID).Count(&count)
+		if got := count == 1; got != tt.want {
+			t.Errorf("invoice %s paid at %v earned commission = %v, want %v", tt.invoiceID, tt.paidAt, got, tt.want)
+		}
+	}
+}
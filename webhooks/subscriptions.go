@@ -0,0 +1,154 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/subscription"
+)
+
+// handleCheckoutSessionCompleted links the customer created for a Checkout
+// session to its user and syncs the new subscription.
+func (h *Handler) handleCheckoutSessionCompleted(event stripe.Event) error {
+	var s stripe.CheckoutSession
+	if err := json.Unmarshal(event.Data.Raw, &s); err != nil {
+		return fmt.Errorf("error parsing checkout session: %w", err)
+	}
+	if s.Mode != stripe.CheckoutSessionModeSubscription || s.Subscription == nil {
+		return nil
+	}
+
+	userID, err := strconv.ParseUint(s.ClientReferenceID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("checkout session %s has no user reference", s.ID)
+	}
+	var user models.User
+	if err := h.DB.First(&user, userID).Error; err != nil {
+		return fmt.Errorf("user %d not found for checkout session %s: %w", userID, s.ID, err)
+	}
+	if s.Customer != nil && (user.StripeCustomerID == nil || *user.StripeCustomerID != s.Customer.ID) {
+		if err := h.DB.Model(&user).Update("stripe_customer_id", s.Customer.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	return h.syncSubscription(s.Subscription.ID)
+}
+
+// handleSubscriptionChanged handles customer.subscription.created, .updated
+// and .deleted.
+func (h *Handler) handleSubscriptionChanged(event stripe.Event) error {
+	var sub stripe.Subscription
+	if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+		return fmt.Errorf("error parsing subscription: %w", err)
+	}
+	return h.syncSubscription(sub.ID)
+}
+
+// syncSubscription copies a subscription's status, period end and plan onto
+// its user. The subscription is fetched from Stripe rather than taken from the
+// event, because events can arrive out of order.
+func (h *Handler) syncSubscription(subscriptionID string) error {
+	sub, err := subscription.Get(subscriptionID, nil)
+	if err != nil {
+		return fmt.Errorf("failed to fetch subscription %s: %w", subscriptionID, err)
+	}
+
+	user, err := h.userForSubscription(sub)
+	if err != nil {
+		return err
+	}
+
+	live := sub.Status != stripe.SubscriptionStatusCanceled && sub.Status != stripe.SubscriptionStatusIncompleteExpired
+	current := user.StripeSubscriptionID != nil && *user.StripeSubscriptionID == sub.ID
+
+	// A stale subscription ending must not downgrade a user who has since
+	// subscribed again.
+	if !live && !current && user.StripeSubscriptionID != nil {
+		fmt.Printf("Ignoring ended subscription %s for user %d, current is %s\n", sub.ID, user.ID, *user.StripeSubscriptionID)
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"subscription_status": subscriptionStatus(sub.Status),
+	}
+	if live {
+		endsAt := time.Unix(sub.CurrentPeriodEnd, 0).UTC()
+		updates["subscription_ends_at"] = endsAt
+		updates["stripe_subscription_id"] = sub.ID
+		updates["plan"] = planForSubscription(sub)
+	} else {
+		endedAt := time.Now().UTC()
+		if sub.EndedAt != 0 {
+			endedAt = time.Unix(sub.EndedAt, 0).UTC()
+		}
+		updates["subscription_ends_at"] = endedAt
+		updates["stripe_subscription_id"] = nil
+		updates["plan"] = plans.Free
+	}
+
+	if err := h.DB.Model(user).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update subscription for user %d: %w", user.ID, err)
+	}
+
+	fmt.Printf("Synced subscription %s for user %d: status=%s plan=%s\n", sub.ID, user.ID, updates["subscription_status"], updates["plan"])
+	return nil
+}
+
+// userForSubscription finds the user a subscription belongs to, by Stripe
+// customer or else by the user_id set in the subscription metadata at checkout.
+func (h *Handler) userForSubscription(sub *stripe.Subscription) (*models.User, error) {
+	var user models.User
+	if sub.Customer != nil {
+		err := h.DB.Where("stripe_customer_id = ?", sub.Customer.ID).First(&user).Error
+		if err == nil {
+			return &user, nil
+		}
+	}
+	if userID, err := strconv.ParseUint(sub.Metadata["user_id"], 10, 64); err == nil {
+		if err := h.DB.First(&user, userID).Error; err == nil {
+			return &user, nil
+		}
+	}
+	return nil, fmt.Errorf("no user found for subscription %s", sub.ID)
+}
+
+// subscriptionStatus maps a Stripe subscription status onto
+// User.SubscriptionStatus.
+func subscriptionStatus(status stripe.SubscriptionStatus) string {
+	switch status {
+	case stripe.SubscriptionStatusActive:
+		return "active"
+	case stripe.SubscriptionStatusTrialing:
+		return "trial"
+	case stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired:
+		return "cancelled"
+	default:
+		// past_due, unpaid, incomplete, paused
+		return string(status)
+	}
+}
+
+// planForSubscription works out which plan a subscription is for from its
+// price, falling back to the plan recorded at checkout.
+func planForSubscription(sub *stripe.Subscription) string {
+	if sub.Items != nil {
+		for _, item := range sub.Items.Data {
+			if item.Price == nil {
+				continue
+			}
+			if plan, ok := plans.ForPrice(item.Price.ID); ok {
+				return plan
+			}
+		}
+	}
+	if plan := sub.Metadata["plan"]; plan != "" {
+		return plan
+	}
+	return plans.Pro
+}
@@ -3,40 +3,138 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"time"
 
 	"github.com/drewmudry/instashorts-api/models"
+	"github.com/drewmudry/instashorts-api/plans"
 	"github.com/drewmudry/instashorts-api/processing"
 	"github.com/drewmudry/instashorts-api/tasks"
 	"gorm.io/gorm" // Import gorm for transaction logic in HandleSceneGeneration
 )
 
-// HandleTitleGeneration processes tasks from the QueueVideoTitle.
-func (p *Processor) HandleTitleGeneration(ctx context.Context, payload string) error {
-	// ... (Load task, video, series, existingTitles - NO CHANGE)
-	var task tasks.TitleTaskPayload
+// videoTask is the shape shared by every video pipeline payload.
+type videoTask struct {
+	VideoID uint `json:"video_id"`
+}
+
+// loadVideoTask decodes a pipeline payload and loads its video and series.
+// Set preloadScenes when the step needs the video's scenes. It returns a nil
+// video (and no error) when the task should be dropped because its series was
+// deleted or the video was cancelled.
+func (p *Processor) loadVideoTask(ctx context.Context, payload string, preloadScenes bool) (*models.Video, *models.Series, error) {
+	var task videoTask
 	if err := json.Unmarshal([]byte(payload), &task); err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	db := p.DB.WithContext(ctx)
+	if preloadScenes {
+		db = db.Preload("Scenes")
 	}
 
-	log.Printf("Processing title for video %d", task.VideoID)
 	var video models.Video
-	if err := p.DB.First(&video, task.VideoID).Error; err != nil {
-		return err
+	if err := db.First(&video, task.VideoID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Dropping task for deleted video %d", task.VideoID)
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	if video.Status == "cancelled" {
+		log.Printf("Dropping task for cancelled video %d", video.ID)
+		return nil, nil, nil
 	}
 
 	var series models.Series
-	if err := p.DB.First(&series, video.SeriesID).Error; err != nil {
+	if err := p.DB.WithContext(ctx).First(&series, video.SeriesID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("Dropping task for video %d of deleted series %d", video.ID, video.SeriesID)
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	return &video, &series, nil
+}
+
+// videoContext prepares ctx for a video's generation steps: LLM calls are
+// attributed to the video's owner, so their cost lands in the usage ledger,
+// and use a model the owner's plan allows.
+func (p *Processor) videoContext(ctx context.Context, video *models.Video, series *models.Series) (context.Context, plans.Entitlements, error) {
+	_, ent, err := plans.Load(p.DB.WithContext(ctx), series.UserID)
+	if err != nil {
+		return ctx, ent, err
+	}
+
+	ctx = processing.WithAttribution(ctx, processing.Attribution{
+		UserID:   series.UserID,
+		SeriesID: series.ID,
+		VideoID:  video.ID,
+	})
+	if model := processing.ModelOf(p.LLM); !ent.AllowsModel(model) {
+		ctx = processing.WithModel(ctx, ent.ChooseModel(model))
+	}
+	return ctx, ent, nil
+}
+
+// recordUsage returns a UsageRecorder that writes to the llm_usage table.
+func recordUsage(db *gorm.DB) processing.UsageRecorder {
+	return func(ctx context.Context, usage models.LLMUsage) error {
+		return db.WithContext(ctx).Create(&usage).Error
+	}
+}
+
+// setStatus records a video's pipeline status. It deliberately ignores ctx so
+// a failure status is still written after a handler times out. A cancelled
+// video stays cancelled, so its next step drops it.
+func (p *Processor) setStatus(video *models.Video, status string) {
+	if err := p.DB.Model(video).Where("status <> ?", "cancelled").Update("status", status).Error; err != nil {
+		log.Printf("Error setting video %d status to %s: %v", video.ID, status, err)
+	}
+}
+
+// HandleExpiredVideo marks a video as expired when its task was dropped for
+// missing its deadline, so it doesn't sit in pending or count against quota.
+func (p *Processor) HandleExpiredVideo(ctx context.Context, payload string) error {
+	var task videoTask
+	if err := json.Unmarshal([]byte(payload), &task); err != nil {
 		return err
 	}
+	return p.DB.WithContext(ctx).Model(&models.Video{}).
+		Where("id = ? AND status = ?", task.VideoID, "pending").
+		Update("status", "expired").Error
+}
 
-	// Update status
-	p.DB.Model(&video).Update("status", "processing_title")
+// HandleTitleGeneration processes tasks from the QueueVideoTitle.
+func (p *Processor) HandleTitleGeneration(ctx context.Context, payload string) error {
+	video, series, err := p.loadVideoTask(ctx, payload, false)
+	if err != nil || video == nil {
+		return err
+	}
+
+	ctx, ent, err := p.videoContext(ctx, video, series)
+	if err != nil {
+		return err
+	}
+
+	// Title generation is the first step, so it is where a video that would
+	// go over the owner's monthly quota is stopped.
+	if err := plans.CheckVideo(p.DB.WithContext(ctx), *video, series.UserID, ent); err != nil {
+		if !errors.Is(err, plans.ErrQuotaExceeded) {
+			return err
+		}
+		log.Printf("Dropping video %d: %v", video.ID, err)
+		p.setStatus(video, "quota_exceeded")
+		return nil
+	}
+
+	log.Printf("Processing title for video %d", video.ID)
+	p.setStatus(video, "processing_title")
 
 	// Get existing titles
 	var existingVideos []models.Video
-	p.DB.Where("series_id = ? AND id != ?", video.SeriesID, video.ID).Find(&existingVideos)
+	p.DB.WithContext(ctx).Where("series_id = ? AND id != ?", video.SeriesID, video.ID).Find(&existingVideos)
 	var existingTitles []string
 	for _, v := range existingVideos {
 		if v.Title != "" {
@@ -45,77 +143,78 @@ func (p *Processor) HandleTitleGeneration(ctx context.Context, payload string) e
 	}
 
 	// Call business logic
-	title, err := processing.GenerateTitle(ctx, series, existingTitles) //
+	title, promptVersion, err := processing.GenerateTitle(ctx, p.LLM, *video, *series, existingTitles)
 	if err != nil {
-		p.DB.Model(&video).Update("status", "failed_title")
+		p.setStatus(video, "failed_title")
 		return err
 	}
 
 	// Save result
-	if err := p.DB.Model(&video).Update("title", title).Error; err != nil {
+	if err := p.DB.WithContext(ctx).Model(video).Updates(map[string]interface{}{
+		"title":                title,
+		"title_prompt_version": promptVersion,
+	}).Error; err != nil {
 		return err
 	}
 	log.Printf("Generated title for video %d: %s", video.ID, title)
 
 	// ---
-	// Chain to the NEW next step: Scene Generation
+	// Chain to the next step: Scene Generation
 	// ---
 	nextTask := tasks.SceneTaskPayload{VideoID: video.ID}
 	if err := p.Enqueue(ctx, tasks.QueueSceneGeneration, nextTask); err != nil {
-		p.DB.Model(&video).Update("status", "failed_queue_scenes")
+		p.setStatus(video, "failed_queue_scenes")
 		return err
 	}
 
 	log.Printf("Queued video %d for scene generation", video.ID)
-	p.DB.Model(&video).Update("status", "pending_scenes") // <-- NEW STATUS
+	p.setStatus(video, "pending_scenes")
 	return nil
 }
 
-// HandleSceneGeneration processes tasks from the QueueSceneGeneration. (NEW HANDLER)
+// HandleSceneGeneration processes tasks from the QueueSceneGeneration.
 func (p *Processor) HandleSceneGeneration(ctx context.Context, payload string) error {
-	var task tasks.SceneTaskPayload
-	if err := json.Unmarshal([]byte(payload), &task); err != nil {
+	video, series, err := p.loadVideoTask(ctx, payload, false)
+	if err != nil || video == nil {
 		return err
 	}
 
-	log.Printf("Processing scenes for video %d", task.VideoID)
-	var video models.Video
-	if err := p.DB.First(&video, task.VideoID).Error; err != nil {
+	ctx, _, err = p.videoContext(ctx, video, series)
+	if err != nil {
 		return err
 	}
 
+	log.Printf("Processing scenes for video %d", video.ID)
 	if video.Title == "" {
-		p.DB.Model(&video).Update("status", "failed_scenes_no_title")
+		p.setStatus(video, "failed_scenes_no_title")
 		return nil // Should not happen in normal flow, but prevent crash
 	}
 
-	var series models.Series
-	if err := p.DB.First(&series, video.SeriesID).Error; err != nil {
-		return err
-	}
-
-	// Update status
-	p.DB.Model(&video).Update("status", "processing_scenes")
+	p.setStatus(video, "processing_scenes")
 
 	// Call business logic to generate scenes and prompts
-	scenes, err := processing.GenerateScenes(ctx, series, video.Title)
+	scenes, promptVersion, err := processing.GenerateScenes(ctx, p.LLM, *video, *series)
 	if err != nil {
-		p.DB.Model(&video).Update("status", "failed_scenes")
+		p.setStatus(video, "failed_scenes")
 		return err
 	}
 
-	// Save scenes to database in a single transaction
-	err = p.DB.Transaction(func(tx *gorm.DB) error {
+	// Save scenes to database in a single transaction, replacing any left
+	// behind by an earlier attempt that failed after saving.
+	err = p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("video_id = ?", video.ID).Delete(&models.VideoScene{}).Error; err != nil {
+			return err
+		}
 		for _, scene := range scenes {
 			scene.VideoID = video.ID
 			if err := tx.Create(&scene).Error; err != nil {
 				return err
 			}
 		}
-		return nil
+		return tx.Model(video).Update("scenes_prompt_version", promptVersion).Error
 	})
 	if err != nil {
-		p.DB.Model(&video).Update("status", "failed_save_scenes")
+		p.setStatus(video, "failed_save_scenes")
 		return err
 	}
 
@@ -126,90 +225,116 @@ func (p *Processor) HandleSceneGeneration(ctx context.Context, payload string) e
 	// ---
 	nextTask := tasks.ScriptTaskPayload{VideoID: video.ID}
 	if err := p.Enqueue(ctx, tasks.QueueVideoScript, nextTask); err != nil {
-		p.DB.Model(&video).Update("status", "failed_queue_script")
+		p.setStatus(video, "failed_queue_script")
 		return err
 	}
 
 	log.Printf("Queued video %d for script generation", video.ID)
-	p.DB.Model(&video).Update("status", "pending_script")
+	p.setStatus(video, "pending_script")
 	return nil
 }
 
 // HandleScriptGeneration processes tasks from the QueueVideoScript.
 func (p *Processor) HandleScriptGeneration(ctx context.Context, payload string) error {
-	var task tasks.ScriptTaskPayload
-	if err := json.Unmarshal([]byte(payload), &task); err != nil {
-		return err
-	}
-
-	log.Printf("Processing script for video %d", task.VideoID)
-	var video models.Video
 	// Preload scenes for the script generator to use
-	if err := p.DB.Preload("Scenes").First(&video, task.VideoID).Error; err != nil {
+	video, series, err := p.loadVideoTask(ctx, payload, true)
+	if err != nil || video == nil {
 		return err
 	}
 
-	var series models.Series
-	if err := p.DB.First(&series, video.SeriesID).Error; err != nil {
+	ctx, _, err = p.videoContext(ctx, video, series)
+	if err != nil {
 		return err
 	}
 
-	// Update status
-	p.DB.Model(&video).Update("status", "processing_script")
+	log.Printf("Processing script for video %d", video.ID)
+	p.setStatus(video, "processing_script")
 
-	// Call business logic (placeholder) - NOW IT SHOULD USE SCENES/TITLE
-	script, err := processing.GenerateScript(ctx, video, series) //
+	// Write the voiceover, one line per scene
+	script, err := processing.GenerateScript(ctx, p.LLM, *video, *series)
 	if err != nil {
-		p.DB.Model(&video).Update("status", "failed_script")
+		p.setStatus(video, "failed_script")
 		return err
 	}
-
-	// Save the script to the database
-	if err := p.DB.Model(&video).Update("script", script).Error; err != nil {
+	text := script.Text()
+
+	// Save the assembled script with its hook and call to action, and each
+	// scene's narration, together.
+	err = p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(video).Updates(map[string]interface{}{
+			"script":                text,
+			"hook":                  script.Hook,
+			"call_to_action":        script.CallToAction,
+			"script_prompt_version": script.PromptVersion,
+		}).Error; err != nil {
+			return err
+		}
+		for _, line := range script.Scenes {
+			if err := tx.Model(&models.VideoScene{}).
+				Where("video_id = ? AND scene_number = ?", video.ID, line.SceneNumber).
+				Updates(map[string]interface{}{
+					"narration":            line.Narration,
+					"narration_word_count": line.WordCount,
+				}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.setStatus(video, "failed_save_script")
 		return err
 	}
-	log.Printf("Generated script for video %d: %s...", video.ID, script[:20]) //
+	log.Printf("Generated script for video %d: %s...", video.ID, truncate(text, 20))
 
 	// ---
 	// RENDERING DISABLED: Mark video as complete after script generation
 	// ---
 	// nextTask := tasks.VideoRenderTaskPayload{VideoID: video.ID}
 	// if err := p.Enqueue(ctx, tasks.QueueVideoRender, nextTask); err != nil {
-	// 	p.DB.Model(&video).Update("status", "failed_queue_render")
+	// 	p.setStatus(video, "failed_queue_render")
 	// 	return err
 	// }
 	// log.Printf("Queued video %d for rendering", video.ID)
-	// p.DB.Model(&video).Update("status", "pending_render")
+	// p.setStatus(video, "pending_render")
 
 	// Mark as complete since rendering is disabled
-	p.DB.Model(&video).Update("status", "complete")
+	p.setStatus(video, "complete")
 	log.Printf("Video %d processing complete (rendering disabled)", video.ID)
 	return nil
 }
 
 // HandleRenderVideo processes tasks from the QueueVideoRender.
 func (p *Processor) HandleRenderVideo(ctx context.Context, payload string) error {
-	var task tasks.RenderTaskPayload
-	if err := json.Unmarshal([]byte(payload), &task); err != nil {
-		return err
-	}
-
-	var video models.Video
-	if err := p.DB.First(&video, task.VideoID).Error; err != nil {
+	video, _, err := p.loadVideoTask(ctx, payload, false)
+	if err != nil || video == nil {
 		return err
 	}
 
-	log.Printf("Rendering video %d (%s)...", task.VideoID, video.Title)
-	p.DB.Model(&video).Update("status", "rendering")
+	log.Printf("Rendering video %d (%s)...", video.ID, video.Title)
+	p.setStatus(video, "rendering")
 
 	// TODO: Add your actual rendering logic here.
 
 	// Simulate work
-	time.Sleep(10 * time.Second)
+	select {
+	case <-time.After(10 * time.Second):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	// This is the final step
-	p.DB.Model(&video).Update("status", "complete")
-	log.Printf("Completed video %d", task.VideoID)
+	p.setStatus(video, "complete")
+	log.Printf("Completed video %d", video.ID)
 
 	return nil
 }
+
+// truncate shortens s to at most n runes for log output.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
@@ -0,0 +1,206 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/tasks"
+)
+
+// ---
+// HANDLER MIDDLEWARE
+// ---
+
+// Middleware wraps a TaskHandler with cross-cutting behaviour.
+type Middleware func(next TaskHandler) TaskHandler
+
+// Use adds middleware to every registered handler. Middleware registered first
+// runs outermost, so it sees the results of everything registered after it.
+func (p *Processor) Use(mw ...Middleware) {
+	p.middleware = append(p.middleware, mw...)
+}
+
+// wrap applies the processor's middleware chain to handler.
+func (p *Processor) wrap(handler TaskHandler) TaskHandler {
+	for i := len(p.middleware) - 1; i >= 0; i-- {
+		handler = p.middleware[i](handler)
+	}
+	return handler
+}
+
+// PanicError is returned by Recover when a handler panics.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panicked: %v", e.Value)
+}
+
+// Recover turns handler panics into errors so one bad task can't kill the worker.
+// The task is then retried or dead-lettered like any other failure.
+func Recover() Middleware {
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, payload string) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, payload)
+		}
+	}
+}
+
+// Timeout cancels a handler's context after d, or after the queue's entry in
+// perQueue if it has one. Handlers must pass ctx down for this to take effect.
+func Timeout(d time.Duration, perQueue map[string]time.Duration) Middleware {
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, payload string) error {
+			timeout := d
+			if env, ok := tasks.EnvelopeFromContext(ctx); ok {
+				if t, ok := perQueue[env.Type]; ok {
+					timeout = t
+				}
+			}
+			if timeout <= 0 {
+				return next(ctx, payload)
+			}
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, payload)
+		}
+	}
+}
+
+// Logging emits one structured log line per task with its outcome and duration.
+func Logging(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, payload string) error {
+			start := time.Now()
+			err := next(ctx, payload)
+
+			attrs := []any{"duration_ms", time.Since(start).Milliseconds()}
+			if env, ok := tasks.EnvelopeFromContext(ctx); ok {
+				attrs = append(attrs,
+					"queue", env.Type,
+					"task_id", env.ID,
+					"attempt", env.Attempt,
+					"correlation_id", env.CorrelationID,
+				)
+			}
+			if span, ok := SpanFromContext(ctx); ok {
+				attrs = append(attrs, "span_id", span.SpanID)
+			}
+
+			var panicErr *PanicError
+			switch {
+			case errors.As(err, &panicErr):
+				logger.Error("task panicked", append(attrs, "error", err, "stack", string(panicErr.Stack))...)
+			case err != nil:
+				logger.Error("task failed", append(attrs, "error", err)...)
+			default:
+				logger.Info("task completed", attrs...)
+			}
+			return err
+		}
+	}
+}
+
+// Metrics holds per-queue task counters, published through expvar.
+type Metrics struct {
+	Processed *expvar.Map // Tasks that completed successfully
+	Failed    *expvar.Map // Tasks whose handler returned an error
+	Panics    *expvar.Map // Tasks whose handler panicked (also counted as failed)
+	RuntimeMs *expvar.Map // Total handler time in milliseconds
+}
+
+// NewMetrics creates task counters published under the given expvar prefix.
+func NewMetrics(prefix string) *Metrics {
+	return &Metrics{
+		Processed: expvar.NewMap(prefix + "_processed"),
+		Failed:    expvar.NewMap(prefix + "_failed"),
+		Panics:    expvar.NewMap(prefix + "_panics"),
+		RuntimeMs: expvar.NewMap(prefix + "_runtime_ms"),
+	}
+}
+
+// Middleware records the outcome of every task in m.
+func (m *Metrics) Middleware() Middleware {
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, payload string) error {
+			queue := "unknown"
+			if env, ok := tasks.EnvelopeFromContext(ctx); ok {
+				queue = env.Type
+			}
+
+			start := time.Now()
+			err := next(ctx, payload)
+			m.RuntimeMs.Add(queue, time.Since(start).Milliseconds())
+
+			var panicErr *PanicError
+			if errors.As(err, &panicErr) {
+				m.Panics.Add(queue, 1)
+			}
+			if err != nil {
+				m.Failed.Add(queue, 1)
+			} else {
+				m.Processed.Add(queue, 1)
+			}
+			return err
+		}
+	}
+}
+
+// Span identifies one handler execution within a task's trace. The trace ID is
+// the envelope's correlation ID, so every task chained from the same request
+// shares it.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string // ID of the task envelope this span processes
+	Start    time.Time
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span started by the Tracing middleware, if any.
+func SpanFromContext(ctx context.Context) (Span, bool) {
+	span, ok := ctx.Value(spanKey{}).(Span)
+	return span, ok
+}
+
+// Tracing starts a span for each task and attaches it to the handler context.
+func Tracing() Middleware {
+	return func(next TaskHandler) TaskHandler {
+		return func(ctx context.Context, payload string) error {
+			span := Span{SpanID: newSpanID(), Start: time.Now()}
+			if env, ok := tasks.EnvelopeFromContext(ctx); ok {
+				span.TraceID = env.CorrelationID
+				span.ParentID = env.ID
+			}
+			return next(context.WithValue(ctx, spanKey{}, span), payload)
+		}
+	}
+}
+
+// newSpanID returns a random 64-bit hex identifier.
+func newSpanID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
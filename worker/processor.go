@@ -2,29 +2,95 @@ package worker
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"sync"
+	"time"
 
+	"github.com/drewmudry/instashorts-api/processing"
 	"github.com/drewmudry/instashorts-api/tasks"
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
-// TaskHandler is a function that processes a task payload.
+// TaskHandler is a function that processes a task payload. The payload is the
+// inner task JSON; the surrounding envelope is available through
+// tasks.EnvelopeFromContext.
 type TaskHandler func(ctx context.Context, payload string) error
 
+// errDeadlineExceeded is recorded for tasks whose envelope deadline passed
+// before they could run.
+var errDeadlineExceeded = errors.New("task deadline exceeded before processing")
+
+const (
+	// DefaultVisibilityTimeout is how long a claimed task may go without a
+	// heartbeat before the reaper assumes its worker died and requeues it.
+	DefaultVisibilityTimeout = 5 * time.Minute
+
+	// DefaultReapInterval is how often the reaper scans for expired tasks.
+	DefaultReapInterval = 30 * time.Second
+
+	// DefaultConcurrency is the number of consumers for queues without an explicit limit.
+	DefaultConcurrency = 1
+
+	// DefaultShutdownTimeout is how long Listen waits for in-flight tasks on shutdown.
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// forceCancelGrace is how long Listen waits for handlers to return after
+	// their context is cancelled before giving up on them.
+	forceCancelGrace = 5 * time.Second
+
+	// claimTimeout is how long a consumer blocks waiting for a task before
+	// checking for shutdown. It is also the longest shutdown waits on an idle
+	// consumer.
+	claimTimeout = 5 * time.Second
+
+	// claimErrorBackoff is how long a consumer waits after a failed claim.
+	claimErrorBackoff = time.Second
+)
+
 // Processor holds dependencies and registered task handlers.
 type Processor struct {
-	DB       *gorm.DB
-	RDB      *redis.Client
-	handlers map[string]TaskHandler
+	DB  *gorm.DB
+	RDB *redis.Client
+	LLM processing.LLM
+
+	// WorkerID identifies this process's processing lists in Redis.
+	WorkerID string
+
+	// VisibilityTimeout and ReapInterval control in-flight task recovery.
+	VisibilityTimeout time.Duration
+	ReapInterval      time.Duration
+
+	// ShutdownTimeout bounds how long Listen waits for running handlers.
+	ShutdownTimeout time.Duration
+
+	handlers      map[string]TaskHandler
+	expired       map[string]TaskHandler
+	retryPolicies map[string]RetryPolicy
+	concurrency   map[string]int
+	middleware    []Middleware
 }
 
-// NewProcessor creates a new worker processor.
-func NewProcessor(db *gorm.DB, rdb *redis.Client) *Processor {
+// NewProcessor creates a new worker processor. Calls made through llm are
+// metered into the llm_usage table.
+func NewProcessor(db *gorm.DB, rdb *redis.Client, llm processing.LLM) *Processor {
 	return &Processor{
-		DB:       db,
-		RDB:      rdb,
-		handlers: make(map[string]TaskHandler),
+		DB:                db,
+		RDB:               rdb,
+		LLM:               processing.NewMeteredLLM(llm, recordUsage(db)),
+		WorkerID:          newWorkerID(),
+		VisibilityTimeout: DefaultVisibilityTimeout,
+		ReapInterval:      DefaultReapInterval,
+		ShutdownTimeout:   DefaultShutdownTimeout,
+		handlers:          make(map[string]TaskHandler),
+		expired:           make(map[string]TaskHandler),
+		retryPolicies:     make(map[string]RetryPolicy),
+		concurrency:       make(map[string]int),
 	}
 }
 
@@ -34,43 +100,237 @@ func (p *Processor) Register(queueName string, handler TaskHandler) {
 	log.Printf("Registered handler for queue: %s", queueName)
 }
 
-// Enqueue is a helper to add a new task to a queue.
-func (p *Processor) Enqueue(ctx context.Context, queueName string, payload interface{}) error {
-	payloadStr, err := tasks.Marshal(payload)
-	if err != nil {
-		return err
+// OnExpired sets a handler to run for tasks on a queue that are dropped
+// because their deadline passed before they started, so their work can be
+// marked as abandoned. It runs once, without retries.
+func (p *Processor) OnExpired(queueName string, handler TaskHandler) {
+	p.expired[queueName] = handler
+}
+
+// SetConcurrency sets how many tasks from a queue may run at the same time.
+// All consumers share the Processor's DB and Redis clients.
+func (p *Processor) SetConcurrency(queueName string, n int) {
+	if n < 1 {
+		n = 1
+	}
+	p.concurrency[queueName] = n
+}
+
+func (p *Processor) concurrencyFor(queueName string) int {
+	if n, ok := p.concurrency[queueName]; ok {
+		return n
 	}
-	return p.RDB.LPush(ctx, queueName, payloadStr).Err()
+	return DefaultConcurrency
 }
 
-// Listen starts the worker, listening on all registered queues.
+// Enqueue is a helper to add a new task to a queue. When called from a
+// handler, the new task inherits the current task's correlation ID.
+func (p *Processor) Enqueue(ctx context.Context, queueName string, payload interface{}, opts ...tasks.EnqueueOption) error {
+	return tasks.Enqueue(ctx, p.RDB, queueName, payload, opts...)
+}
+
+// Listen starts the worker, listening on all registered queues. It blocks
+// until ctx is cancelled and every in-flight task has been finished or
+// requeued.
+//
+// Each queue gets its own pool of goroutines (see SetConcurrency), so a slow
+// step can't starve the others. Tasks are claimed atomically into a per-worker
+// processing list and are only removed from it once their handler returns. A
+// background reaper requeues any task whose visibility timeout expired, so a
+// crashed worker never loses work. Failed tasks are retried with backoff
+// according to the queue's RetryPolicy and dead-lettered once they run out of
+// attempts.
+//
+// On shutdown, consumers stop claiming new tasks and running handlers get
+// ShutdownTimeout to finish. Handlers still running after that have their
+// context cancelled, and anything left in the processing lists is put back at
+// the front of its queue.
 func (p *Processor) Listen(ctx context.Context, queueNames ...string) {
-	log.Printf("Worker listening on %d queues: %v", len(queueNames), queueNames)
+	log.Printf("Worker %s listening on %d queues: %v", p.WorkerID, len(queueNames), queueNames)
 
-	for {
-		// BRPop blocks until a task is available on any of the listed queues.
-		result, err := p.RDB.BRPop(ctx, 0, queueNames...).Result()
-		if err != nil {
-			log.Printf("Error popping from queue: %v", err)
-			continue
-		}
+	go p.reap(ctx, queueNames)
+	go p.promote(ctx, queueNames)
 
-		// result[0] is the queue name, result[1] is the payload
-		queueName := result[0]
-		payload := result[1]
+	// Handlers get their own context so that a shutdown signal stops new
+	// claims without interrupting work that is already under way.
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
 
+	var wg sync.WaitGroup
+	for _, queueName := range queueNames {
 		handler, ok := p.handlers[queueName]
 		if !ok {
 			log.Printf("Error: No handler registered for queue %s", queueName)
 			continue
 		}
+		handler = p.wrap(handler)
+
+		n := p.concurrencyFor(queueName)
+		log.Printf("Starting %d consumers for queue %s", n, queueName)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(queueName string) {
+				defer wg.Done()
+				p.consume(ctx, handlerCtx, queueName, handler)
+			}(queueName)
+		}
+	}
+
+	<-ctx.Done()
+	log.Printf("Worker %s shutting down, waiting up to %s for in-flight tasks", p.WorkerID, p.ShutdownTimeout)
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(p.ShutdownTimeout):
+		log.Printf("Shutdown timeout reached, cancelling in-flight tasks")
+		cancelHandlers()
+		select {
+		case <-drained:
+		case <-time.After(forceCancelGrace):
+			log.Printf("Some handlers ignored cancellation, requeueing their tasks anyway")
+		}
+	}
+
+	// Use a fresh context: ctx is already cancelled.
+	for _, queueName := range queueNames {
+		n, err := p.requeueOwn(context.Background(), queueName)
+		if err != nil {
+			log.Printf("Error requeueing in-flight tasks on %s: %v", queueName, err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Requeued %d unfinished tasks on queue %s", n, queueName)
+		}
+	}
+	log.Printf("Worker %s stopped", p.WorkerID)
+}
+
+// consume claims and runs tasks from a single queue until ctx is cancelled.
+// Handlers run with handlerCtx, which outlives ctx during shutdown.
+func (p *Processor) consume(ctx, handlerCtx context.Context, queueName string, handler TaskHandler) {
+	for ctx.Err() == nil {
+		t, err := p.claim(ctx, queueName)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error claiming task from %s: %v", queueName, err)
+			}
+			sleep(ctx, claimErrorBackoff)
+			continue
+		}
+		if t == nil {
+			continue
+		}
+		p.handle(handlerCtx, t, handler)
+	}
+}
 
-		log.Printf("Received task from queue %s", queueName)
+// handle runs a claimed task's handler and acknowledges, retries or
+// dead-letters it. Handlers run with handlerCtx, which outlives the consumer's
+// context during shutdown.
+func (p *Processor) handle(handlerCtx context.Context, t *claimedTask, handler TaskHandler) {
+	// Queue bookkeeping must still succeed after the consumer is cancelled.
+	bg := context.Background()
+	queueName := t.queue
 
-		// Run the handler
-		if err := handler(ctx, payload); err != nil {
-			log.Printf("Error processing task from %s: %v", queueName, err)
-			// TODO: Add error handling (e.g., move to a dead-letter queue)
+	env, err := tasks.Decode(t.payload, queueName)
+	if err != nil {
+		log.Printf("Discarding malformed task from %s: %v", queueName, err)
+		if err := p.deadLetter(bg, t, err); err != nil {
+			log.Printf("Error dead-lettering task from %s: %v", queueName, err)
+		}
+		return
+	}
+	t.env = env
+
+	if env.Expired(time.Now()) {
+		if onExpired, ok := p.expired[queueName]; ok {
+			if err := onExpired(tasks.WithEnvelope(bg, env), string(env.Payload)); err != nil {
+				log.Printf("Error handling expired task %s from %s: %v", env.ID, queueName, err)
+			}
+		}
+		if err := p.deadLetter(bg, t, errDeadlineExceeded); err != nil {
+			log.Printf("Error dead-lettering task from %s: %v", queueName, err)
 		}
+		return
+	}
+
+	log.Printf("Received task %s from queue %s (attempt %d, correlation %s)", env.ID, t.queue, env.Attempt, env.CorrelationID)
+
+	// Run the handler
+	err = p.run(handlerCtx, t, handler)
+	if err != nil && handlerCtx.Err() != nil {
+		// Cancelled by shutdown: leave it in the processing list so
+		// Listen puts it back on the queue without burning an attempt.
+		log.Printf("Task from %s interrupted by shutdown: %v", t.queue, err)
+		return
+	}
+	if err != nil {
+		log.Printf("Error processing task from %s: %v", t.queue, err)
+		if err := p.fail(bg, t, err); err != nil {
+			log.Printf("Error recording failure for task from %s: %v", t.queue, err)
+		}
+		return
+	}
+
+	if err := p.succeed(bg, t); err != nil {
+		log.Printf("Error acknowledging task from %s: %v", t.queue, err)
+	}
+}
+
+// run executes handler while keeping the task's visibility deadline fresh.
+func (p *Processor) run(ctx context.Context, t *claimedTask, handler TaskHandler) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(p.VisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := p.heartbeat(ctx, t); err != nil {
+					log.Printf("Error extending visibility for task from %s: %v", t.queue, err)
+				}
+			}
+		}
+	}()
+
+	ctx = tasks.WithEnvelope(ctx, t.env)
+	if t.env.Deadline != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, *t.env.Deadline)
+		defer cancel()
+	}
+
+	return handler(ctx, string(t.env.Payload))
+}
+
+// sleep waits for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// newWorkerID builds an identifier that is unique per process, even when
+// containers reuse the same hostname and PID across restarts.
+func newWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "worker"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
 	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
 }
@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/tasks"
+)
+
+func TestHandleDropsExpiredTasks(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	var expired []string
+	p.OnExpired(testQueue, func(ctx context.Context, payload string) error {
+		if _, ok := tasks.EnvelopeFromContext(ctx); !ok {
+			t.Error("expired handler ran without the task envelope")
+		}
+		expired = append(expired, payload)
+		return nil
+	})
+
+	if err := tasks.Enqueue(context.Background(), p.RDB, testQueue, tasks.TitleTaskPayload{VideoID: 3},
+		tasks.WithDeadline(time.Now().Add(-time.Minute))); err != nil {
+		t.Fatal(err)
+	}
+	task := mustClaim(t, p)
+	p.handle(context.Background(), task, func(ctx context.Context, payload string) error {
+		t.Error("handler ran for an expired task")
+		return nil
+	})
+
+	if len(expired) != 1 || expired[0] != `{"video_id":3}` {
+		t.Errorf("expired handler got %v, want the task payload once", expired)
+	}
+	letters, _ := p.DeadLetters(context.Background(), testQueue, 10)
+	if len(letters) != 1 || letters[0].Error != errDeadlineExceeded.Error() {
+		t.Errorf("dead letters = %+v, want the expired task", letters)
+	}
+	if mr.Exists(processingKey(testQueue, p.WorkerID)) {
+		t.Error("expired task left in the processing list")
+	}
+}
+
+func TestHandleBoundsHandlerByDeadline(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := tasks.Enqueue(context.Background(), p.RDB, testQueue, tasks.TitleTaskPayload{VideoID: 3},
+		tasks.WithDeadline(deadline)); err != nil {
+		t.Fatal(err)
+	}
+
+	task := mustClaim(t, p)
+	ran := false
+	p.handle(context.Background(), task, func(ctx context.Context, payload string) error {
+		ran = true
+		if got, ok := ctx.Deadline(); !ok || !got.Equal(deadline) {
+			t.Errorf("handler deadline = %v, want %v", got, deadline)
+		}
+		return nil
+	})
+	if !ran {
+		t.Error("handler didn't run before the deadline")
+	}
+}
+
+// failAndRedeliver fails a task and claims its retry.
+func failAndRedeliver(t *testing.T, p *Processor, task *claimedTask) *claimedTask {
+	t.Helper()
+	if err := p.fail(context.Background(), task, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.promoteDue(context.Background(), testQueue, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	retry := mustClaim(t, p)
+	env, err := tasks.Decode(retry.payload, testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry.env = env
+	return retry
+}
+
+func TestFailCountsAttempts(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	p.SetRetryPolicy(testQueue, RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	first := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: 3})
+	second := failAndRedeliver(t, p, first)
+	third := failAndRedeliver(t, p, second)
+
+	if second.env.Attempt != 2 || third.env.Attempt != 3 {
+		t.Errorf("attempts = %d, %d, want 2, 3", second.env.Attempt, third.env.Attempt)
+	}
+	if third.env.ID != first.env.ID || third.env.CorrelationID != first.env.CorrelationID {
+		t.Errorf("retry changed the task identity: %+v", third.env)
+	}
+	if second.env.FirstFailedAt == nil || third.env.FirstFailedAt == nil {
+		t.Fatal("retry lost its first failure time")
+	}
+	if !third.env.FirstFailedAt.Equal(*second.env.FirstFailedAt) {
+		t.Errorf("first failure time moved from %s to %s", second.env.FirstFailedAt, third.env.FirstFailedAt)
+	}
+}
+
+func TestFailDeadLettersTasksPastDeadline(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	task := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: 3}, tasks.WithDeadline(time.Now().Add(-time.Second)))
+
+	// Retrying would only see the task expire on its next delivery
+	if err := p.fail(context.Background(), task, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+	letters, _ := p.DeadLetters(context.Background(), testQueue, 10)
+	if len(letters) != 1 || letters[0].TaskID != task.env.ID {
+		t.Errorf("dead letters = %+v, want the expired task", letters)
+	}
+}
+
+func TestReplayDeadLettersResetsAttemptsAndDeadline(t *testing.T) {
+	p, _ := newTestProcessor(t)
+	p.SetRetryPolicy(testQueue, RetryPolicy{MaxAttempts: 1})
+	task := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: 3}, tasks.WithDeadline(time.Now().Add(time.Hour)))
+	if err := p.fail(context.Background(), task, errors.New("boom")); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := p.ReplayDeadLetters(context.Background(), testQueue, 10); err != nil || n != 1 {
+		t.Fatalf("ReplayDeadLetters = %d, %v", n, err)
+	}
+	replayed := mustClaim(t, p)
+	env, err := tasks.Decode(replayed.payload, testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.ID != task.env.ID || env.Attempt != 1 || env.Deadline != nil || env.FirstFailedAt != nil {
+		t.Errorf("replayed envelope = %+v, want task %s with a fresh budget", env, task.env.ID)
+	}
+}
@@ -0,0 +1,214 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/tasks"
+	"github.com/go-redis/redis/v8"
+)
+
+// ---
+// RELIABLE QUEUE
+// ---
+// Every queue has two companion keys:
+//   <queue>:processing:<worker>  list of tasks this worker has claimed
+//   <queue>:inflight             zset of "<processing key>|<payload>" scored by visibility deadline
+// A task only leaves the processing list when it is acknowledged or requeued.
+
+// claimedTask is a task that has been moved into this worker's processing list.
+type claimedTask struct {
+	queue   string
+	payload string          // Raw value as stored in Redis, used for acknowledgement
+	env     *tasks.Envelope // Decoded envelope; nil if the payload was malformed
+}
+
+// adoptOrphansScript gives a visibility deadline to every task in a
+// processing list that has none, which happens if a worker dies between
+// claiming a task and recording its deadline. Tasks that already have one are
+// left alone.
+var adoptOrphansScript = redis.NewScript(`
+local n = 0
+for _, payload in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	n = n + redis.call('ZADD', KEYS[2], 'NX', ARGV[2], ARGV[1] .. payload)
+end
+return n
+`)
+
+// requeueScript puts an expired task back on its queue if it is still in flight.
+var requeueScript = redis.NewScript(`
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+if redis.call('LREM', KEYS[2], 1, ARGV[2]) > 0 then
+	redis.call('RPUSH', KEYS[3], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// requeueOwnScript drains a processing list back onto the front of its queue,
+// keeping the order the tasks were claimed in. The newest claim is at the head
+// of the processing list, so it goes back first and ends up behind the older
+// ones.
+var requeueOwnScript = redis.NewScript(`
+local n = 0
+while true do
+	local payload = redis.call('LPOP', KEYS[1])
+	if not payload then
+		break
+	end
+	redis.call('ZREM', KEYS[2], ARGV[1] .. payload)
+	redis.call('RPUSH', KEYS[3], payload)
+	n = n + 1
+end
+return n
+`)
+
+func processingKey(queueName, workerID string) string {
+	return queueName + ":processing:" + workerID
+}
+
+func inflightKey(queueName string) string {
+	return queueName + ":inflight"
+}
+
+func inflightMember(t *claimedTask, workerID string) string {
+	return processingKey(t.queue, workerID) + "|" + t.payload
+}
+
+// claim moves the next task on queueName into this worker's processing list,
+// blocking for up to claimTimeout while the queue is empty. It returns nil if
+// no task arrived in that time.
+func (p *Processor) claim(ctx context.Context, queueName string) (*claimedTask, error) {
+	processing := processingKey(queueName, p.WorkerID)
+	payload, err := p.RDB.BRPopLPush(ctx, queueName, processing, claimTimeout).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocking commands can't run in a script, so the deadline is recorded
+	// separately. Should this fail or the worker die first, the reaper adopts
+	// the task from the processing list.
+	t := &claimedTask{queue: queueName, payload: payload}
+	deadline := time.Now().Add(p.VisibilityTimeout).UnixMilli()
+	if err := p.RDB.ZAdd(context.Background(), inflightKey(queueName), &redis.Z{
+		Score:  float64(deadline),
+		Member: inflightMember(t, p.WorkerID),
+	}).Err(); err != nil {
+		log.Printf("Error recording deadline of task from %s: %v", queueName, err)
+	}
+	return t, nil
+}
+
+// ackPipe queues the commands that remove a task from the processing list and
+// the in-flight set, so callers can combine them with their own writes.
+func (p *Processor) ackPipe(ctx context.Context, pipe redis.Pipeliner, t *claimedTask) {
+	pipe.LRem(ctx, processingKey(t.queue, p.WorkerID), 1, t.payload)
+	pipe.ZRem(ctx, inflightKey(t.queue), inflightMember(t, p.WorkerID))
+}
+
+// heartbeat pushes the task's visibility deadline forward while its handler runs.
+func (p *Processor) heartbeat(ctx context.Context, t *claimedTask) error {
+	deadline := time.Now().Add(p.VisibilityTimeout).UnixMilli()
+	return p.RDB.ZAddXX(ctx, inflightKey(t.queue), &redis.Z{
+		Score:  float64(deadline),
+		Member: inflightMember(t, p.WorkerID),
+	}).Err()
+}
+
+// reap periodically requeues tasks whose visibility timeout has expired.
+func (p *Processor) reap(ctx context.Context, queueNames []string) {
+	ticker := time.NewTicker(p.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, queueName := range queueNames {
+				if n, err := p.adoptOrphans(ctx, queueName); err != nil {
+					log.Printf("Error adopting orphaned tasks on queue %s: %v", queueName, err)
+				} else if n > 0 {
+					log.Printf("Adopted %d orphaned tasks on queue %s", n, queueName)
+				}
+
+				n, err := p.requeueExpired(ctx, queueName)
+				if err != nil {
+					log.Printf("Error reaping queue %s: %v", queueName, err)
+					continue
+				}
+				if n > 0 {
+					log.Printf("Requeued %d expired tasks on queue %s", n, queueName)
+				}
+			}
+		}
+	}
+}
+
+// requeueExpired moves every expired in-flight task on queueName back onto the queue.
+func (p *Processor) requeueExpired(ctx context.Context, queueName string) (int, error) {
+	now := time.Now().UnixMilli()
+	members, err := p.RDB.ZRangeByScore(ctx, inflightKey(queueName), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now, 10),
+		Count: 100,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, member := range members {
+		sep := strings.Index(member, "|")
+		if sep < 0 {
+			p.RDB.ZRem(ctx, inflightKey(queueName), member)
+			continue
+		}
+		processing, payload := member[:sep], member[sep+1:]
+
+		keys := []string{inflightKey(queueName), processing, queueName}
+		n, err := requeueScript.Run(ctx, p.RDB, keys, member, payload, now).Int()
+		if err != nil {
+			return requeued, err
+		}
+		requeued += n
+	}
+	return requeued, nil
+}
+
+// adoptOrphans gives a visibility deadline to tasks in any worker's
+// processing list for queueName that lack one, so requeueExpired recovers
+// them once it passes.
+func (p *Processor) adoptOrphans(ctx context.Context, queueName string) (int, error) {
+	deadline := time.Now().Add(p.VisibilityTimeout).UnixMilli()
+	adopted := 0
+	iter := p.RDB.Scan(ctx, 0, queueName+":processing:*", 100).Iterator()
+	for iter.Next(ctx) {
+		processing := iter.Val()
+		keys := []string{processing, inflightKey(queueName)}
+		n, err := adoptOrphansScript.Run(ctx, p.RDB, keys, processing+"|", deadline).Int()
+		if err != nil {
+			return adopted, err
+		}
+		adopted += n
+	}
+	return adopted, iter.Err()
+}
+
+// requeueOwn returns every task still in this worker's processing list for
+// queueName to the front of the queue. It is used during shutdown.
+func (p *Processor) requeueOwn(ctx context.Context, queueName string) (int, error) {
+	processing := processingKey(queueName, p.WorkerID)
+	keys := []string{processing, inflightKey(queueName), queueName}
+	return requeueOwnScript.Run(ctx, p.RDB, keys, processing+"|").Int()
+}
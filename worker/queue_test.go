@@ -0,0 +1,192 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+const testQueue = "q_test"
+
+// newTestProcessor returns a processor backed by an in-memory Redis.
+func newTestProcessor(t *testing.T) (*Processor, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	p := NewProcessor(nil, rdb, nil)
+	p.WorkerID = "test-worker"
+	return p, mr
+}
+
+// push adds raw payloads to the queue in the order they should be claimed.
+func push(t *testing.T, p *Processor, payloads ...string) {
+	t.Helper()
+	for _, payload := range payloads {
+		if err := p.RDB.LPush(context.Background(), testQueue, payload).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mustClaim claims the next task, failing the test if there is none.
+func mustClaim(t *testing.T, p *Processor) *claimedTask {
+	t.Helper()
+	task, err := p.claim(context.Background(), testQueue)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if task == nil {
+		t.Fatal("claim returned no task")
+	}
+	return task
+}
+
+func TestClaimTracksTaskUntilAcknowledged(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	push(t, p, `{"video_id":1}`, `{"video_id":2}`)
+
+	task := mustClaim(t, p)
+	if task.payload != `{"video_id":1}` {
+		t.Fatalf("claimed %s, want the oldest task", task.payload)
+	}
+
+	processing := processingKey(testQueue, p.WorkerID)
+	if got, _ := mr.List(processing); len(got) != 1 || got[0] != task.payload {
+		t.Errorf("processing list = %v, want the claimed task", got)
+	}
+	deadline, err := mr.ZScore(inflightKey(testQueue), inflightMember(task, p.WorkerID))
+	if err != nil {
+		t.Fatalf("claimed task has no visibility deadline: %v", err)
+	}
+	if deadline <= float64(time.Now().UnixMilli()) {
+		t.Errorf("visibility deadline %v is not in the future", deadline)
+	}
+
+	if err := p.succeed(context.Background(), task); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+	if mr.Exists(processing) {
+		t.Error("acknowledged task left in the processing list")
+	}
+	if members, _ := mr.ZMembers(inflightKey(testQueue)); len(members) != 0 {
+		t.Errorf("acknowledged task left in flight: %v", members)
+	}
+	if got, _ := mr.List(testQueue); len(got) != 1 {
+		t.Errorf("queue = %v, want the unclaimed task only", got)
+	}
+}
+
+func TestRequeueExpiredReturnsTasksPastTheirDeadline(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	push(t, p, "expired", "running")
+
+	// A negative timeout gives the first task a deadline in the past
+	p.VisibilityTimeout = -time.Second
+	expired := mustClaim(t, p)
+	p.VisibilityTimeout = time.Minute
+	running := mustClaim(t, p)
+
+	n, err := p.requeueExpired(context.Background(), testQueue)
+	if err != nil {
+		t.Fatalf("requeueExpired: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("requeued %d tasks, want 1", n)
+	}
+	if got, _ := mr.List(testQueue); len(got) != 1 || got[0] != expired.payload {
+		t.Errorf("queue = %v, want the expired task back", got)
+	}
+	if got, _ := mr.List(processingKey(testQueue, p.WorkerID)); len(got) != 1 || got[0] != running.payload {
+		t.Errorf("processing list = %v, want only the running task", got)
+	}
+
+	// Once requeued it isn't requeued again
+	if n, _ := p.requeueExpired(context.Background(), testQueue); n != 0 {
+		t.Errorf("second pass requeued %d tasks, want 0", n)
+	}
+}
+
+func TestHeartbeatKeepsTaskInFlight(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	push(t, p, "slow")
+
+	p.VisibilityTimeout = -time.Second
+	task := mustClaim(t, p)
+	p.VisibilityTimeout = time.Minute
+	if err := p.heartbeat(context.Background(), task); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+
+	if n, _ := p.requeueExpired(context.Background(), testQueue); n != 0 {
+		t.Errorf("requeued %d tasks after a heartbeat, want 0", n)
+	}
+	if mr.Exists(testQueue) {
+		t.Error("task went back on the queue while its handler was running")
+	}
+}
+
+func TestAdoptOrphansRecoversTasksWithoutDeadline(t *testing.T) {
+	p, mr := newTestProcessor(t)
+
+	// A worker that died between BRPOPLPUSH and recording the deadline
+	// leaves its task in a processing list with no in-flight entry
+	dead := processingKey(testQueue, "dead-worker")
+	mr.Lpush(dead, "orphan")
+
+	p.VisibilityTimeout = -time.Second
+	n, err := p.adoptOrphans(context.Background(), testQueue)
+	if err != nil {
+		t.Fatalf("adoptOrphans: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("adopted %d tasks, want 1", n)
+	}
+	if n, _ := p.adoptOrphans(context.Background(), testQueue); n != 0 {
+		t.Errorf("adopted %d tasks twice", n)
+	}
+
+	if n, _ := p.requeueExpired(context.Background(), testQueue); n != 1 {
+		t.Fatalf("requeued %d adopted tasks, want 1", n)
+	}
+	if got, _ := mr.List(testQueue); len(got) != 1 || got[0] != "orphan" {
+		t.Errorf("queue = %v, want the orphan back", got)
+	}
+	if mr.Exists(dead) {
+		t.Error("orphan left in the dead worker's processing list")
+	}
+}
+
+func TestRequeueOwnPutsUnfinishedTasksFirst(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	push(t, p, "first", "second", "third")
+	mustClaim(t, p)
+	mustClaim(t, p)
+
+	n, err := p.requeueOwn(context.Background(), testQueue)
+	if err != nil {
+		t.Fatalf("requeueOwn: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("requeued %d tasks, want 2", n)
+	}
+
+	// Tasks are claimed from the right, so that end holds the next one
+	got, _ := mr.List(testQueue)
+	want := []string{"third", "second", "first"}
+	if len(got) != len(want) {
+		t.Fatalf("queue = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("queue = %v, want %v", got, want)
+		}
+	}
+	if members, _ := mr.ZMembers(inflightKey(testQueue)); len(members) != 0 {
+		t.Errorf("requeued tasks left in flight: %v", members)
+	}
+}
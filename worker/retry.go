@@ -0,0 +1,260 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/tasks"
+	"github.com/go-redis/redis/v8"
+)
+
+// ---
+// RETRIES AND DEAD LETTERS
+// ---
+// Failed tasks are re-encoded with their envelope's attempt counter bumped and
+// parked in <queue>:delayed, a zset scored by the time they may run again.
+// Once a task runs out of attempts it is moved to the <queue>:dead list.
+
+// RetryPolicy controls how a failed task on a queue is retried.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts, including the first one
+	BaseDelay   time.Duration // Delay before the first retry
+	MaxDelay    time.Duration // Upper bound for the exponential delay
+}
+
+// DefaultRetryPolicy is used for queues without an explicit policy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    10 * time.Minute,
+}
+
+// Backoff returns the delay before retrying after the given failed attempt,
+// doubling per attempt with "equal jitter" so retries don't stampede.
+func (r RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < attempt && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// DeadLetter is a task that exhausted its retries.
+type DeadLetter struct {
+	Queue         string    `json:"queue"`
+	TaskID        string    `json:"task_id"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Payload       string    `json:"payload"` // The encoded task envelope
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	FailedAt      time.Time `json:"failed_at"`
+}
+
+// promoteScript moves due tasks from the delayed set back onto their queue.
+var promoteScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 100)
+for _, payload in ipairs(due) do
+	redis.call('ZREM', KEYS[1], payload)
+	redis.call('LPUSH', KEYS[2], payload)
+end
+return #due
+`)
+
+// replayScript moves a single dead letter back onto its queue.
+var replayScript = redis.NewScript(`
+if redis.call('LREM', KEYS[1], -1, ARGV[1]) > 0 then
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+	return 1
+end
+return 0
+`)
+
+func delayedKey(queueName string) string {
+	return queueName + ":delayed"
+}
+
+func deadKey(queueName string) string {
+	return queueName + ":dead"
+}
+
+// SetRetryPolicy overrides the retry policy for a queue.
+func (p *Processor) SetRetryPolicy(queueName string, policy RetryPolicy) {
+	p.retryPolicies[queueName] = policy
+}
+
+func (p *Processor) retryPolicy(queueName string) RetryPolicy {
+	if policy, ok := p.retryPolicies[queueName]; ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// succeed acknowledges a task.
+func (p *Processor) succeed(ctx context.Context, t *claimedTask) error {
+	_, err := p.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		p.ackPipe(ctx, pipe, t)
+		return nil
+	})
+	return err
+}
+
+// fail acknowledges a failed task and either schedules a retry or dead-letters it.
+func (p *Processor) fail(ctx context.Context, t *claimedTask, taskErr error) error {
+	now := time.Now().UTC()
+	policy := p.retryPolicy(t.queue)
+
+	if t.env == nil || t.env.Attempt >= policy.MaxAttempts || t.env.Expired(now) {
+		return p.deadLetter(ctx, t, taskErr)
+	}
+
+	env := *t.env
+	if env.FirstFailedAt == nil {
+		env.FirstFailedAt = &now
+	}
+	env.Attempt++
+	encoded, err := env.Encode()
+	if err != nil {
+		return err
+	}
+	delay := policy.Backoff(t.env.Attempt)
+
+	log.Printf("Retrying task %s from %s in %s (attempt %d of %d)", env.ID, t.queue, delay, env.Attempt, policy.MaxAttempts)
+	_, err = p.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, delayedKey(t.queue), &redis.Z{
+			Score:  float64(now.Add(delay).UnixMilli()),
+			Member: encoded,
+		})
+		p.ackPipe(ctx, pipe, t)
+		return nil
+	})
+	return err
+}
+
+// deadLetter acknowledges a task and records it on the queue's dead-letter list.
+func (p *Processor) deadLetter(ctx context.Context, t *claimedTask, taskErr error) error {
+	now := time.Now().UTC()
+	letter := DeadLetter{
+		Queue:         t.queue,
+		Payload:       t.payload,
+		Error:         taskErr.Error(),
+		Attempts:      1,
+		FirstFailedAt: now,
+		FailedAt:      now,
+	}
+	if t.env != nil {
+		letter.TaskID = t.env.ID
+		letter.CorrelationID = t.env.CorrelationID
+		letter.Attempts = t.env.Attempt
+		if t.env.FirstFailedAt != nil {
+			letter.FirstFailedAt = *t.env.FirstFailedAt
+		}
+	}
+
+	dead, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Moving task %s from %s to dead-letter queue after %d attempts: %v", letter.TaskID, t.queue, letter.Attempts, taskErr)
+	_, err = p.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, deadKey(t.queue), dead)
+		p.ackPipe(ctx, pipe, t)
+		return nil
+	})
+	return err
+}
+
+// promote periodically moves due retries back onto their queues.
+func (p *Processor) promote(ctx context.Context, queueNames []string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+			for _, queueName := range queueNames {
+				if _, err := p.promoteDue(ctx, queueName, now); err != nil {
+					log.Printf("Error promoting delayed tasks on %s: %v", queueName, err)
+				}
+			}
+		}
+	}
+}
+
+// promoteDue moves the retries on queueName that are due by now back onto
+// the queue, returning how many it moved.
+func (p *Processor) promoteDue(ctx context.Context, queueName string, now time.Time) (int, error) {
+	keys := []string{delayedKey(queueName), queueName}
+	return promoteScript.Run(ctx, p.RDB, keys, strconv.FormatInt(now.UnixMilli(), 10)).Int()
+}
+
+// DeadLetters returns up to limit dead letters for a queue, newest first.
+func (p *Processor) DeadLetters(ctx context.Context, queueName string, limit int64) ([]DeadLetter, error) {
+	raws, err := p.RDB.LRange(ctx, deadKey(queueName), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(raws))
+	for _, raw := range raws {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(raw), &letter); err != nil {
+			log.Printf("Skipping malformed dead letter on %s: %v", queueName, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters moves up to limit of the oldest dead letters back onto
+// their queue with a fresh retry budget. It returns how many were replayed.
+func (p *Processor) ReplayDeadLetters(ctx context.Context, queueName string, limit int64) (int, error) {
+	raws, err := p.RDB.LRange(ctx, deadKey(queueName), -limit, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for i := len(raws) - 1; i >= 0; i-- {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(raws[i]), &letter); err != nil {
+			log.Printf("Skipping malformed dead letter on %s: %v", queueName, err)
+			continue
+		}
+
+		// Reset the retry budget but keep the task ID and correlation ID.
+		payload := letter.Payload
+		if env, err := tasks.Decode(letter.Payload, queueName); err == nil {
+			env.Attempt = 1
+			env.FirstFailedAt = nil
+			env.Deadline = nil
+			if encoded, err := env.Encode(); err == nil {
+				payload = encoded
+			}
+		}
+
+		keys := []string{deadKey(queueName), queueName}
+		n, err := replayScript.Run(ctx, p.RDB, keys, raws[i], payload).Int()
+		if err != nil {
+			return replayed, err
+		}
+		replayed += n
+	}
+	return replayed, nil
+}
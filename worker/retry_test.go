@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/drewmudry/instashorts-api/tasks"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration // Before jitter
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{3, 40 * time.Second},
+		{4, time.Minute},
+		{9, time.Minute},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := policy.Backoff(tt.attempt)
+			if got < tt.want/2 || got > tt.want {
+				t.Fatalf("Backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.want/2, tt.want)
+			}
+		}
+	}
+}
+
+// claimEnqueued enqueues payload on the test queue and claims it, decoding
+// its envelope as consume does.
+func claimEnqueued(t *testing.T, p *Processor, payload interface{}, opts ...tasks.EnqueueOption) *claimedTask {
+	t.Helper()
+	if err := tasks.Enqueue(context.Background(), p.RDB, testQueue, payload, opts...); err != nil {
+		t.Fatal(err)
+	}
+	task := mustClaim(t, p)
+	env, err := tasks.Decode(task.payload, testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.env = env
+	return task
+}
+
+func TestFailSchedulesRetryWithBackoff(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	p.SetRetryPolicy(testQueue, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour})
+
+	task := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: 7})
+	before := time.Now()
+	if err := p.fail(context.Background(), task, errors.New("boom")); err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+
+	if mr.Exists(processingKey(testQueue, p.WorkerID)) {
+		t.Error("failed task left in the processing list")
+	}
+	if mr.Exists(testQueue) {
+		t.Error("failed task requeued immediately instead of after a delay")
+	}
+	delayed, err := mr.ZMembers(delayedKey(testQueue))
+	if err != nil || len(delayed) != 1 {
+		t.Fatalf("delayed set = %v (%v), want the failed task", delayed, err)
+	}
+	score, _ := mr.ZScore(delayedKey(testQueue), delayed[0])
+	due := time.UnixMilli(int64(score))
+	if due.Before(before.Add(30*time.Second)) || due.After(before.Add(time.Minute+time.Second)) {
+		t.Errorf("retry due at %s, want 30-60s after %s", due, before)
+	}
+
+	// Not due yet, then due
+	if n, _ := p.promoteDue(context.Background(), testQueue, time.Now()); n != 0 {
+		t.Errorf("promoted %d tasks before they were due", n)
+	}
+	if n, _ := p.promoteDue(context.Background(), testQueue, due); n != 1 {
+		t.Fatalf("promoted %d due tasks, want 1", n)
+	}
+	if got, _ := mr.List(testQueue); len(got) != 1 || got[0] != delayed[0] {
+		t.Errorf("queue = %v, want the retried task", got)
+	}
+	if mr.Exists(delayedKey(testQueue)) {
+		t.Error("promoted task left in the delayed set")
+	}
+}
+
+func TestFailDeadLettersExhaustedTasks(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	p.SetRetryPolicy(testQueue, RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	task := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: 7})
+	if err := p.fail(context.Background(), task, errors.New("first")); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := p.promoteDue(context.Background(), testQueue, time.Now().Add(time.Second)); n != 1 {
+		t.Fatalf("promoted %d tasks, want 1", n)
+	}
+	retry := mustClaim(t, p)
+	env, err := tasks.Decode(retry.payload, testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retry.env = env
+	if err := p.fail(context.Background(), retry, errors.New("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	if mr.Exists(delayedKey(testQueue)) || mr.Exists(testQueue) {
+		t.Error("exhausted task was retried again")
+	}
+	letters, err := p.DeadLetters(context.Background(), testQueue, 10)
+	if err != nil {
+		t.Fatalf("DeadLetters: %v", err)
+	}
+	if len(letters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(letters))
+	}
+	letter := letters[0]
+	if letter.TaskID != task.env.ID || letter.Error != "second" || letter.Attempts != 2 {
+		t.Errorf("dead letter = %+v, want task %s failing with %q after 2 attempts", letter, task.env.ID, "second")
+	}
+}
+
+func TestReplayDeadLettersRequeuesOldestFirst(t *testing.T) {
+	p, mr := newTestProcessor(t)
+	p.SetRetryPolicy(testQueue, RetryPolicy{MaxAttempts: 1})
+
+	var ids []string
+	for i := uint(1); i <= 3; i++ {
+		task := claimEnqueued(t, p, tasks.TitleTaskPayload{VideoID: i})
+		if err := p.fail(context.Background(), task, errors.New("boom")); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.env.ID)
+	}
+
+	n, err := p.ReplayDeadLetters(context.Background(), testQueue, 2)
+	if err != nil {
+		t.Fatalf("ReplayDeadLetters: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("replayed %d dead letters, want 2", n)
+	}
+
+	for _, want := range ids[:2] {
+		task := mustClaim(t, p)
+		env, err := tasks.Decode(task.payload, testQueue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if env.ID != want {
+			t.Errorf("replayed task %s, want %s", env.ID, want)
+		}
+	}
+	if got, _ := mr.List(deadKey(testQueue)); len(got) != 1 {
+		t.Errorf("%d dead letters left, want 1", len(got))
+	}
+}